@@ -0,0 +1,69 @@
+package lamplighter
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// BoundedSchedule clamps the activation times of a schedule to a range of
+// clock times within each day, for example "sunset -1h, but never before
+// 16:30". A nil bound leaves that side of the range open.
+type BoundedSchedule struct {
+	Schedule  cron.Schedule `json:"schedule"`
+	NotBefore *Clock        `json:"not_before,omitempty"`
+	NotAfter  *Clock        `json:"not_after,omitempty"`
+}
+
+// Next returns the first clamped activation time of the underlying
+// schedule after now
+//
+// This implements robfig/cron.Schedule
+func (s BoundedSchedule) Next(now time.Time) time.Time {
+	next, _ := s.next(now)
+	return next
+}
+
+// IsFallback reports whether the next activation time after now is the
+// underlying schedule's fallback time
+//
+// This implements FallbackSchedule
+func (s BoundedSchedule) IsFallback(now time.Time) bool {
+	return wrappedFallback(s.Schedule, s.next, now)
+}
+
+// next returns the next clamped activation time and the time from which
+// the underlying schedule produced it
+func (s BoundedSchedule) next(now time.Time) (next, from time.Time) {
+	// Clamping never moves a time to another day, so any event earlier
+	// today may still be clamped forward past now
+	from = Clock(0).On(now).Add(-time.Nanosecond)
+	for i := 0; i < maxSearchDays; i++ {
+		t := s.Schedule.Next(from)
+		if t.IsZero() {
+			break
+		}
+
+		next = s.clamp(t.In(now.Location()))
+		if next.After(now) {
+			return next, from
+		}
+		from = t
+	}
+
+	return time.Time{}, time.Time{}
+}
+
+func (s BoundedSchedule) clamp(t time.Time) time.Time {
+	if s.NotBefore != nil {
+		if bound := s.NotBefore.On(t); t.Before(bound) {
+			return bound
+		}
+	}
+	if s.NotAfter != nil {
+		if bound := s.NotAfter.On(t); t.After(bound) {
+			return bound
+		}
+	}
+	return t
+}
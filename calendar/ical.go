@@ -0,0 +1,475 @@
+// Package calendar reads the events in iCalendar (.ics) files so that
+// scheduled jobs can be skipped or replaced on particular days.
+//
+// Only the parts of RFC 5545 needed to find which days an event falls on
+// are supported: DTSTART, DTEND, DURATION, SUMMARY, CATEGORIES, simple
+// RRULEs using FREQ, INTERVAL, COUNT, and UNTIL, and EXDATE and
+// RECURRENCE-ID to remove or move single occurrences.
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+)
+
+// Frequency is the unit of an event's recurrence
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// Recurrence describes how an event repeats
+type Recurrence struct {
+	Frequency Frequency
+	Interval  int
+	Count     int       // zero if unlimited
+	Until     time.Time // zero if unlimited
+}
+
+// Event is a calendar event. All day events start at midnight in the
+// local time zone.
+type Event struct {
+	UID        string
+	Summary    string
+	Categories []string
+	Start      time.Time
+	End        time.Time
+	AllDay     bool
+	Recurrence *Recurrence
+
+	// Excluded are the starts of occurrences which don't take place,
+	// either because they're listed in an EXDATE or because another
+	// event with the same UID replaces them
+	Excluded []time.Time
+
+	// RecurrenceID is the start of the occurrence of another event which
+	// this event replaces, if any
+	RecurrenceID time.Time
+}
+
+// Calendar is the set of events in an iCalendar file
+type Calendar struct {
+	Events []Event
+}
+
+// Open reads the calendar from the given file
+func Open(filename string) (*Calendar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read calendar file: %w", err)
+	}
+	defer f.Close()
+
+	cal, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return cal, nil
+}
+
+// Parse reads a calendar in iCalendar format. Events with unsupported
+// recurrence rules are skipped.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := &Calendar{}
+	var event *Event
+	var duration time.Duration
+	var skip bool
+	for i, line := range lines {
+		name, params, value, ok := parseLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &Event{}
+			duration, skip = 0, false
+		case name == "END" && value == "VEVENT":
+			if event == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN:VEVENT", i+1)
+			}
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", i+1, event.Summary)
+			}
+
+			if event.End.IsZero() {
+				switch {
+				case duration > 0:
+					event.End = event.Start.Add(duration)
+				case event.AllDay:
+					event.End = event.Start.AddDate(0, 0, 1)
+				default:
+					event.End = event.Start
+				}
+			}
+
+			if !skip {
+				cal.Events = append(cal.Events, *event)
+			}
+			event = nil
+		case event == nil:
+			continue
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescape(value)
+		case name == "CATEGORIES":
+			for _, category := range splitText(value) {
+				event.Categories = append(event.Categories, unescape(strings.TrimSpace(category)))
+			}
+		case name == "DTSTART":
+			event.Start, event.AllDay, err = parseTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse DTSTART: %w", i+1, err)
+			}
+		case name == "DTEND":
+			event.End, _, err = parseTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse DTEND: %w", i+1, err)
+			}
+		case name == "DURATION":
+			duration, err = parseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse DURATION: %w", i+1, err)
+			}
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseTime(params, v)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: parse EXDATE: %w", i+1, err)
+				}
+				event.Excluded = append(event.Excluded, t)
+			}
+		case name == "RECURRENCE-ID":
+			event.RecurrenceID, _, err = parseTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse RECURRENCE-ID: %w", i+1, err)
+			}
+		case name == "RRULE":
+			event.Recurrence, err = parseRecurrence(value)
+			if err != nil {
+				log.Printf("ERR: calendar line %d: skipping event %q: %s", i+1, event.Summary, err)
+				skip = true
+			}
+		}
+	}
+
+	cal.applyOverrides()
+	return cal, nil
+}
+
+// applyOverrides excludes each occurrence which is replaced by an event
+// with a RECURRENCE-ID from the recurring event with the same UID. The
+// replacement is kept as an event of its own.
+func (c *Calendar) applyOverrides() {
+	for _, override := range c.Events {
+		if override.RecurrenceID.IsZero() {
+			continue
+		}
+
+		for i, event := range c.Events {
+			if event.UID == override.UID && event.Recurrence != nil && event.RecurrenceID.IsZero() {
+				c.Events[i].Excluded = append(c.Events[i].Excluded, override.RecurrenceID)
+			}
+		}
+	}
+}
+
+// On returns the first event matching the query which takes place on the
+// same local day as t. An empty query matches every event; otherwise the
+// query must appear in the event's summary or one of its categories,
+// ignoring case.
+func (c *Calendar) On(t time.Time, query string) (Event, bool) {
+	t = t.Local()
+	dayStart := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	for _, event := range c.Events {
+		if event.Matches(query) && event.occursBetween(dayStart, dayEnd) {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+// Matches reports whether the query appears in the event's summary or
+// one of its categories, ignoring case. An empty query matches every
+// event.
+func (e Event) Matches(query string) bool {
+	if query == "" {
+		return true
+	}
+
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(e.Summary), query) {
+		return true
+	}
+	for _, category := range e.Categories {
+		if strings.Contains(strings.ToLower(category), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// occursBetween reports whether any occurrence of the event overlaps the
+// interval [start, end)
+func (e Event) occursBetween(start, end time.Time) bool {
+	overlaps := func(s time.Time) bool {
+		length := e.End.Sub(e.Start)
+		// zero length events still mark the day they start on
+		return s.Before(end) && (s.Add(length).After(start) || (length == 0 && !s.Before(start)))
+	}
+
+	if e.Recurrence == nil {
+		return overlaps(e.Start) && !e.excluded(e.Start)
+	}
+
+	r := e.Recurrence
+	// estimate the occurrence nearest to the interval, then check enough
+	// occurrences on either side to cover the event's length
+	var estimate, span int
+	switch r.Frequency {
+	case Daily:
+		estimate = int(start.Sub(e.Start).Hours() / 24 / float64(r.Interval))
+		span = int(e.End.Sub(e.Start).Hours()/24/float64(r.Interval)) + 1
+	case Weekly:
+		estimate = int(start.Sub(e.Start).Hours() / 24 / 7 / float64(r.Interval))
+		span = int(e.End.Sub(e.Start).Hours()/24/7/float64(r.Interval)) + 1
+	case Monthly:
+		estimate = ((start.Year()-e.Start.Year())*12 + int(start.Month()-e.Start.Month())) / r.Interval
+		span = 1
+	case Yearly:
+		estimate = (start.Year() - e.Start.Year()) / r.Interval
+		span = 1
+	}
+
+	for n := estimate - span; n <= estimate+1; n++ {
+		if n < 0 || (r.Count > 0 && n >= r.Count) {
+			continue
+		}
+
+		s := e.occurrence(n)
+		if !r.Until.IsZero() && s.After(r.Until) {
+			continue
+		}
+		if overlaps(s) && !e.excluded(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether the occurrence starting at s doesn't take place
+func (e Event) excluded(s time.Time) bool {
+	for _, t := range e.Excluded {
+		if t.Equal(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence returns the start of the nth occurrence of the event
+func (e Event) occurrence(n int) time.Time {
+	r := e.Recurrence
+	switch r.Frequency {
+	case Daily:
+		return e.Start.AddDate(0, 0, n*r.Interval)
+	case Weekly:
+		return e.Start.AddDate(0, 0, 7*n*r.Interval)
+	case Monthly:
+		return e.Start.AddDate(0, n*r.Interval, 0)
+	default:
+		return e.Start.AddDate(n*r.Interval, 0, 0)
+	}
+}
+
+// unfold reads content lines, joining lines which continue on the next
+// line with leading whitespace
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read calendar: %w", err)
+	}
+	return lines, nil
+}
+
+// parseLine splits a content line into its name, parameters, and value
+func parseLine(line string) (name string, params map[string]string, value string, ok bool) {
+	head, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(head, ";")
+	params = make(map[string]string)
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+
+	return strings.ToUpper(parts[0]), params, value, true
+}
+
+// parseTime parses a DATE or DATE-TIME value. Floating times, which have
+// neither a UTC suffix nor a TZID, are in the local time zone.
+func parseTime(params map[string]string, value string) (t time.Time, allDay bool, err error) {
+	if params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		t, err = time.ParseInLocation(dateLayout, value, time.Local)
+		return t, true, err
+	}
+
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	} else if tzid, ok := params["TZID"]; ok {
+		loc, err = time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	t, err = time.ParseInLocation(dateTimeLayout, value, loc)
+	return t, false, err
+}
+
+// parseDuration parses a DURATION value, such as "P1D" or "PT1H30M"
+func parseDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(value, "+"), "P")
+	if s == value || s == "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var d time.Duration
+	inTime := false
+	number := ""
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			number += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		number = ""
+
+		switch {
+		case r == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case r == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+
+	if number != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// parseRecurrence parses an RRULE value
+func parseRecurrence(value string) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.Frequency = Frequency(strings.ToUpper(v))
+			switch r.Frequency {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return nil, fmt.Errorf("unsupported frequency %q", v)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(v)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(v)
+		case "UNTIL":
+			r.Until, _, err = parseTime(nil, v)
+		case "WKST":
+			// only affects rules with BYDAY, which aren't supported
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule part %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", k, err)
+		}
+	}
+
+	if r.Frequency == "" {
+		return nil, fmt.Errorf("recurrence rule has no FREQ")
+	}
+	return r, nil
+}
+
+// splitText splits a list of TEXT values on commas which aren't escaped.
+// The values are left escaped.
+func splitText(s string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case ',':
+			values = append(values, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, s[start:])
+}
+
+// unescape replaces escaped characters in a TEXT value
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
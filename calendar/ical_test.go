@@ -0,0 +1,386 @@
+package calendar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parse reads the given VEVENT lines as a calendar
+func parse(t *testing.T, lines ...string) *Calendar {
+	t.Helper()
+
+	body := "BEGIN:VCALENDAR\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+	cal, err := Parse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("parse calendar: %s", err)
+	}
+	return cal
+}
+
+func TestUnfold(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "unfolded",
+			input: "BEGIN:VEVENT\r\nSUMMARY:Holiday\r\nEND:VEVENT\r\n",
+			want:  []string{"BEGIN:VEVENT", "SUMMARY:Holiday", "END:VEVENT"},
+		},
+		{
+			name:  "space",
+			input: "SUMMARY:Long \r\n holiday\r\n",
+			want:  []string{"SUMMARY:Long holiday"},
+		},
+		{
+			name:  "tab",
+			input: "SUMMARY:Long\n\t holiday\n",
+			want:  []string{"SUMMARY:Long holiday"},
+		},
+		{
+			name:  "several continuations",
+			input: "CATEGORIES:a,\r\n b,\r\n c\r\nSUMMARY:x\r\n",
+			want:  []string{"CATEGORIES:a,b,c", "SUMMARY:x"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := unfold(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatalf("unfold: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		params     map[string]string
+		value      string
+		want       time.Time
+		wantAllDay bool
+	}{
+		{
+			name:       "date",
+			params:     map[string]string{"VALUE": "DATE"},
+			value:      "20261225",
+			want:       time.Date(2026, 12, 25, 0, 0, 0, 0, time.Local),
+			wantAllDay: true,
+		},
+		{
+			name:       "date without value parameter",
+			value:      "20261225",
+			want:       time.Date(2026, 12, 25, 0, 0, 0, 0, time.Local),
+			wantAllDay: true,
+		},
+		{
+			name:  "floating date-time",
+			value: "20261225T170000",
+			want:  time.Date(2026, 12, 25, 17, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "utc date-time",
+			value: "20261225T170000Z",
+			want:  time.Date(2026, 12, 25, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "date-time with tzid",
+			params: map[string]string{"TZID": "America/New_York"},
+			value:  "20261225T170000",
+			want:   time.Date(2026, 12, 25, 17, 0, 0, 0, newYork),
+		},
+		{
+			name:   "utc suffix overrides tzid",
+			params: map[string]string{"TZID": "America/New_York"},
+			value:  "20261225T170000Z",
+			want:   time.Date(2026, 12, 25, 17, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, allDay, err := parseTime(test.params, test.value)
+			if err != nil {
+				t.Fatalf("parse time: %s", err)
+			}
+			if !got.Equal(test.want) || got.Location().String() != test.want.Location().String() {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+			if allDay != test.wantAllDay {
+				t.Errorf("got all day %t, want %t", allDay, test.wantAllDay)
+			}
+		})
+	}
+
+	_, _, err = parseTime(map[string]string{"TZID": "Nowhere/Special"}, "20261225T170000")
+	if err == nil {
+		t.Error("expected error for unknown tzid")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "P1D", want: 24 * time.Hour},
+		{value: "P1W", want: 7 * 24 * time.Hour},
+		{value: "PT1H30M", want: 90 * time.Minute},
+		{value: "P1DT12H", want: 36 * time.Hour},
+		{value: "+PT15S", want: 15 * time.Second},
+		{value: "P", wantErr: true},
+		{value: "1H", wantErr: true},
+		{value: "P1H", wantErr: true},
+		{value: "PT1D", wantErr: true},
+		{value: "PT1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseDuration(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse duration: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventEnd(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  time.Time
+	}{
+		{
+			name:  "dtend",
+			lines: []string{"DTSTART:20261225T170000", "DTEND:20261225T190000"},
+			want:  time.Date(2026, 12, 25, 19, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "duration",
+			lines: []string{"DTSTART:20261225T170000", "DURATION:PT90M"},
+			want:  time.Date(2026, 12, 25, 18, 30, 0, 0, time.Local),
+		},
+		{
+			name:  "all day",
+			lines: []string{"DTSTART;VALUE=DATE:20261225"},
+			want:  time.Date(2026, 12, 26, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:  "instant",
+			lines: []string{"DTSTART:20261225T170000"},
+			want:  time.Date(2026, 12, 25, 17, 0, 0, 0, time.Local),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := append([]string{"BEGIN:VEVENT"}, test.lines...)
+			cal := parse(t, append(lines, "END:VEVENT")...)
+			if len(cal.Events) != 1 {
+				t.Fatalf("got %d events, want 1", len(cal.Events))
+			}
+			if got := cal.Events[0].End; !got.Equal(test.want) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCategories(t *testing.T) {
+	cal := parse(t,
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20261225",
+		`CATEGORIES:Holidays\, family,Work\\Home, Travel`,
+		"END:VEVENT",
+	)
+
+	want := []string{"Holidays, family", `Work\Home`, "Travel"}
+	if got := cal.Events[0].Categories; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 12, 0, 0, 0, time.Local)
+	}
+
+	// events in other time zones fall on whichever local day they start
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+	inNewYork := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 19, 0, 0, 0, newYork).Local()
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		on    map[time.Time]bool
+	}{
+		{
+			name:  "single all day",
+			lines: []string{"DTSTART;VALUE=DATE:20261225"},
+			on: map[time.Time]bool{
+				day(2026, 12, 24): false,
+				day(2026, 12, 25): true,
+				day(2026, 12, 26): false,
+			},
+		},
+		{
+			name:  "multiple days",
+			lines: []string{"DTSTART;VALUE=DATE:20261224", "DTEND;VALUE=DATE:20261227"},
+			on: map[time.Time]bool{
+				day(2026, 12, 23): false,
+				day(2026, 12, 24): true,
+				day(2026, 12, 26): true,
+				day(2026, 12, 27): false,
+			},
+		},
+		{
+			name:  "daily interval",
+			lines: []string{"DTSTART;VALUE=DATE:20260101", "RRULE:FREQ=DAILY;INTERVAL=3"},
+			on: map[time.Time]bool{
+				day(2025, 12, 29): false,
+				day(2026, 1, 1):   true,
+				day(2026, 1, 2):   false,
+				day(2026, 1, 4):   true,
+				day(2026, 3, 2):   true,
+				day(2026, 3, 3):   false,
+			},
+		},
+		{
+			name:  "weekly count",
+			lines: []string{"DTSTART:20260105T190000", "DURATION:PT1H", "RRULE:FREQ=WEEKLY;COUNT=3"},
+			on: map[time.Time]bool{
+				day(2026, 1, 5):  true,
+				day(2026, 1, 6):  false,
+				day(2026, 1, 12): true,
+				day(2026, 1, 19): true,
+				day(2026, 1, 26): false,
+			},
+		},
+		{
+			name:  "weekly interval until",
+			lines: []string{"DTSTART:20260105T190000", "RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20260202T235959"},
+			on: map[time.Time]bool{
+				day(2026, 1, 5):  true,
+				day(2026, 1, 12): false,
+				day(2026, 1, 19): true,
+				day(2026, 2, 2):  true,
+				day(2026, 2, 16): false,
+			},
+		},
+		{
+			name:  "monthly",
+			lines: []string{"DTSTART;VALUE=DATE:20260115", "RRULE:FREQ=MONTHLY"},
+			on: map[time.Time]bool{
+				day(2026, 1, 15): true,
+				day(2026, 2, 15): true,
+				day(2026, 2, 16): false,
+				day(2027, 7, 15): true,
+			},
+		},
+		{
+			name:  "yearly interval count",
+			lines: []string{"DTSTART;VALUE=DATE:20261225", "RRULE:FREQ=YEARLY;INTERVAL=2;COUNT=2"},
+			on: map[time.Time]bool{
+				day(2026, 12, 25): true,
+				day(2027, 12, 25): false,
+				day(2028, 12, 25): true,
+				day(2030, 12, 25): false,
+			},
+		},
+		{
+			name:  "exdate",
+			lines: []string{"DTSTART;VALUE=DATE:20260101", "RRULE:FREQ=DAILY", "EXDATE;VALUE=DATE:20260103,20260105", "EXDATE;VALUE=DATE:20260107"},
+			on: map[time.Time]bool{
+				day(2026, 1, 2): true,
+				day(2026, 1, 3): false,
+				day(2026, 1, 4): true,
+				day(2026, 1, 5): false,
+				day(2026, 1, 7): false,
+			},
+		},
+		{
+			name:  "exdate with tzid",
+			lines: []string{"DTSTART;TZID=America/New_York:20260105T190000", "RRULE:FREQ=WEEKLY", "EXDATE:20260113T000000Z"},
+			on: map[time.Time]bool{
+				inNewYork(2026, 1, 5):  true,
+				inNewYork(2026, 1, 12): false,
+				inNewYork(2026, 1, 19): true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := append([]string{"BEGIN:VEVENT", "SUMMARY:Event"}, test.lines...)
+			cal := parse(t, append(lines, "END:VEVENT")...)
+			for d, want := range test.on {
+				if _, got := cal.On(d, ""); got != want {
+					t.Errorf("%s: got %t, want %t", d.Format(dateLayout), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecurrenceID(t *testing.T) {
+	cal := parse(t,
+		"BEGIN:VEVENT",
+		"UID:book-club",
+		"SUMMARY:Book club",
+		"DTSTART:20260105T190000",
+		"RRULE:FREQ=WEEKLY",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:book-club",
+		"SUMMARY:Book club (moved)",
+		"RECURRENCE-ID:20260112T190000",
+		"DTSTART:20260114T190000",
+		"END:VEVENT",
+	)
+
+	tests := []struct {
+		day         time.Time
+		wantSummary string
+	}{
+		{day: time.Date(2026, 1, 5, 12, 0, 0, 0, time.Local), wantSummary: "Book club"},
+		{day: time.Date(2026, 1, 12, 12, 0, 0, 0, time.Local)},
+		{day: time.Date(2026, 1, 14, 12, 0, 0, 0, time.Local), wantSummary: "Book club (moved)"},
+		{day: time.Date(2026, 1, 19, 12, 0, 0, 0, time.Local), wantSummary: "Book club"},
+	}
+
+	for _, test := range tests {
+		event, ok := cal.On(test.day, "")
+		if ok != (test.wantSummary != "") || event.Summary != test.wantSummary {
+			t.Errorf("%s: got %q (%t), want %q", test.day.Format(dateLayout), event.Summary, ok, test.wantSummary)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package lamplighter
+
+import (
+	"time"
+)
+
+// SunElevation returns the sun's elevation, in degrees above the horizon,
+// at the given location and time
+func SunElevation(location Location, t time.Time) float64 {
+	utc := t.UTC()
+	day := newSolarDay(location.Longitude, utc.Year(), utc.Month(), utc.Day())
+	return day.elevation(location.Latitude, t)
+}
+
+// CircadianCurve maps the sun's elevation to a color temperature and
+// brightness. While the sun is at or below Low, the curve is at its
+// warmest and dimmest; at or above High, it's at its coolest and
+// brightest. In between, both are interpolated linearly.
+type CircadianCurve struct {
+	Location Location `json:"location"`
+	Low      float64  `json:"low"`  // degrees above the horizon
+	High     float64  `json:"high"` // degrees above the horizon
+
+	MinKelvin     uint16  `json:"min_kelvin"`
+	MaxKelvin     uint16  `json:"max_kelvin"`
+	MinBrightness float64 `json:"min_brightness"` // 0-100
+	MaxBrightness float64 `json:"max_brightness"` // 0-100
+}
+
+// At returns the color temperature and brightness of the curve at time t
+func (c CircadianCurve) At(t time.Time) (kelvin uint16, brightness float64) {
+	progress := 0.0
+	if c.High > c.Low {
+		progress = (SunElevation(c.Location, t) - c.Low) / (c.High - c.Low)
+	}
+	if progress < 0 {
+		progress = 0
+	} else if progress > 1 {
+		progress = 1
+	}
+
+	kelvin = c.MinKelvin + uint16(float64(c.MaxKelvin-c.MinKelvin)*progress+0.5)
+	brightness = c.MinBrightness + (c.MaxBrightness-c.MinBrightness)*progress
+	return kelvin, brightness
+}
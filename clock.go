@@ -0,0 +1,64 @@
+package lamplighter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock is a time of day, stored as the duration since midnight
+type Clock time.Duration
+
+// ParseClock parses a 24-hour time of day in the format "15:04" or
+// "15:04:05"
+func ParseClock(s string) (Clock, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return NewClock(t.Hour(), t.Minute(), t.Second()), nil
+		}
+	}
+
+	return 0, fmt.Errorf("parse clock %q: expected format HH:MM or HH:MM:SS", s)
+}
+
+func NewClock(hour, minute, second int) Clock {
+	return Clock(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second)
+}
+
+// On returns the clock time on the same date and in the same location
+// as t
+func (c Clock) On(t time.Time) time.Time {
+	d := time.Duration(c)
+	return time.Date(
+		t.Year(),
+		t.Month(),
+		t.Day(),
+		int(d/time.Hour),
+		int(d%time.Hour/time.Minute),
+		int(d%time.Minute/time.Second),
+		0,
+		t.Location(),
+	)
+}
+
+func (c Clock) String() string {
+	d := time.Duration(c)
+	s := fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	if seconds := int(d % time.Minute / time.Second); seconds != 0 {
+		s += fmt.Sprintf(":%02d", seconds)
+	}
+	return s
+}
+
+func (c Clock) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Clock) UnmarshalText(text []byte) error {
+	parsed, err := ParseClock(string(text))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
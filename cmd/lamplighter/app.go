@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/calendar"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+	"github.com/subtlepseudonym/lamplighter/frame"
+
+	"github.com/robfig/cron/v3"
+)
+
+// app holds the running config along with the devices and cron built
+// from it. All three are replaced together when the config is reloaded.
+type app struct {
+	mu      sync.RWMutex
+	cfg     *config.Config
+	devices map[string]device.Device
+	cron    *cron.Cron
+	seed    int64
+
+	reloadMu     sync.Mutex // serializes reloads
+	transitions  *tracker
+	scenes       *sceneStore
+	jobs         *jobStore
+	pauses       *pauses // circadian curves paused by manual changes
+	holds        *holds  // devices whose scheduled jobs are suspended
+	paused       *pauseState
+	connectivity *connectivity
+}
+
+// snapshot returns the current config, devices, and cron
+func (a *app) snapshot() (*config.Config, map[string]device.Device, *cron.Cron) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cfg, a.devices, a.cron
+}
+
+// connectDevices connects to each configured device. Devices which are
+// already connected and whose config hasn't changed are reused. The
+// outcome of each connection attempt is recorded in status.
+func connectDevices(cfg *config.Config, previous *config.Config, connected map[string]device.Device, status *connectivity) map[string]device.Device {
+	devices := make(map[string]device.Device)
+	for label, dev := range cfg.Devices {
+		if d, ok := connected[label]; ok && previous != nil && reflect.DeepEqual(previous.Devices[label], dev) {
+			devices[label] = d
+			continue
+		}
+
+		d, err := device.Connect(label, dev)
+		if err != nil {
+			status.Failed(label, time.Now())
+			log.Printf("ERR: connect to device: %s", err)
+			continue
+		}
+		status.Seen(label, time.Now())
+		devices[label] = d
+		log.Printf("registered device: %q %s", label, devices[label])
+	}
+
+	return devices
+}
+
+// missingDevices returns the labels of devices referenced by jobs which
+// are not registered
+func missingDevices(cfg *config.Config, devices map[string]device.Device) []string {
+	var missing []string
+	for _, job := range cfg.Jobs {
+		for _, label := range cfg.Targets(job) {
+			if _, ok := devices[label]; !ok {
+				missing = append(missing, label)
+			}
+		}
+	}
+	return missing
+}
+
+// buildCron creates a cron containing every job in the config whose
+// device is registered. Group and scene jobs are scheduled once for each
+// of their devices, so cron runs them concurrently. Unlike applyScene, a
+// scheduled scene isn't atomic: each device's entry is skipped or fails
+// on its own. Jobs which can't be scheduled are skipped and their errors
+// returned.
+func (a *app) buildCron(cfg *config.Config, devices map[string]device.Device, seed int64) (*cron.Cron, []error) {
+	var errs []error
+	now := time.Now() // used for logging cron entries
+	lightCron := cron.New()
+
+	calendars, err := cfg.OpenCalendars()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range cfg.Jobs {
+		errs = append(errs, a.scheduleJob(lightCron, cfg, i, devices, calendars, seed, now)...)
+	}
+
+	errs = append(errs, a.scheduleCircadian(lightCron, cfg, devices)...)
+
+	if cfg.Away.Enabled {
+		err := a.scheduleAway(lightCron, cfg.Away, devices, seed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule away mode: %w", err))
+		}
+	}
+
+	return lightCron, errs
+}
+
+// scheduleJob adds an entry to lightCron for each registered device
+// targeted by the config's i-th job. The job's index offsets seed, so a
+// job's jitter doesn't depend on which cron it's added to.
+func (a *app) scheduleJob(lightCron *cron.Cron, cfg *config.Config, i int, devices map[string]device.Device, calendars map[string]*calendar.Calendar, seed int64, now time.Time) []error {
+	job := cfg.Jobs[i]
+	schedule, err := parseSchedule(cfg, job, seed+int64(i))
+	if err != nil {
+		return []error{fmt.Errorf("jobs[%d]: %w", i, err)}
+	}
+
+	var errs []error
+	for _, label := range cfg.Targets(job) {
+		if _, ok := devices[label]; !ok {
+			log.Printf("ERR: device %q not registered, skipping job", label)
+			continue
+		}
+
+		color, transition, err := stateColor(cfg.State(job, label))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("jobs[%d]: %s: %w", i, label, err))
+			continue
+		}
+
+		j := Job{
+			ID:           job.ID,
+			Label:        label,
+			Device:       devices[label],
+			Group:        job.Group,
+			Scene:        job.Scene,
+			Color:        color,
+			Transition:   transition,
+			Tracker:      a.transitions,
+			Holds:        a.holds,
+			Enabled:      job.IsEnabled(),
+			Pauses:       a.paused,
+			Connectivity: a.connectivity,
+			Exceptions:   newExceptions(cfg, job, label, calendars),
+		}
+		if job.Effect != nil {
+			j.Effect, err = jobEffect(job.Effect, color)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("jobs[%d]: %s: %w", i, label, err))
+				continue
+			}
+		}
+		if job.Zones != nil {
+			j.Zones, err = zonePattern(job.Zones, color)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("jobs[%d]: %s: %w", i, label, err))
+				continue
+			}
+		}
+		if job.Matrix != nil {
+			if job.Matrix.Frame != "" {
+				j.Frame, err = frame.Open(job.Matrix.Frame)
+			} else {
+				j.MatrixEffect, err = jobMatrixEffect(job.Matrix)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("jobs[%d]: %s: %w", i, label, err))
+				continue
+			}
+		}
+		// expired one-shot and dated jobs are left out of the cron
+		next := schedule.Next(now)
+		if next.IsZero() {
+			log.Printf("schedule %q has no upcoming events, skipping job: %s", job.Schedule, label)
+			continue
+		}
+
+		lightCron.Schedule(schedule, j)
+		log.Printf("job: %s: %s", next.Local().Format(time.RFC3339), label)
+	}
+
+	return errs
+}
+
+// stateColor converts a configured device state into a device color and
+// transition
+func stateColor(state config.State) (*device.Color, time.Duration, error) {
+	// conversion formulas are defined by lifx LAN documentation
+	// https://lan.developer.lifx.com/docs/representing-color-with-hsbk
+	color := &device.Color{
+		Hue:        uint16((state.Hue * 0x10000 / 360.0) % 0x10000),
+		Saturation: uint16(state.Saturation * math.MaxUint16 / 100.0),
+		Brightness: uint16(state.Brightness * math.MaxUint16 / 100.0),
+		Kelvin:     uint16(state.Kelvin),
+	}
+
+	// effect jobs have no transition
+	if state.Transition == "" {
+		return color, 0, nil
+	}
+
+	transition, err := time.ParseDuration(state.Transition)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse transition: %w", err)
+	}
+
+	return color, transition, nil
+}
+
+// parseSchedule builds the job's schedule, including its solar options
+// and jitter
+func parseSchedule(cfg *config.Config, job config.Job, seed int64) (cron.Schedule, error) {
+	schedule, err := job.ParseSchedule(cfg.Location)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule: %w", err)
+	}
+
+	jitter := job.Jitter
+	if jitter == "" && cfg.Away.Enabled {
+		jitter = cfg.Away.Jitter
+	}
+	if jitter != "" {
+		window, err := time.ParseDuration(jitter)
+		if err != nil {
+			return nil, fmt.Errorf("parse job jitter: %w", err)
+		}
+		schedule = lamplighter.JitterSchedule{
+			Schedule: schedule,
+			Window:   window,
+			Seed:     seed,
+		}
+	}
+
+	return schedule, nil
+}
+
+// deviceRouter dispatches requests under /device/{label} to the currently
+// registered device, so that routes follow devices across reloads
+func (a *app) deviceRouter(w http.ResponseWriter, r *http.Request) {
+	label, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/device/"), "/")
+
+	_, devices, _ := a.snapshot()
+	dev, ok := devices[label]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "device not found"}`))
+		return
+	}
+
+	switch action {
+	case "":
+		var until time.Time
+		if param := r.URL.Query().Get("hold"); param != "" {
+			cfg, _, _ := a.snapshot()
+			var err error
+			until, err = parseHold(param, cfg.Location, time.Now())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": %q}`, err.Error())
+				return
+			}
+		}
+
+		a.transitions.Clear(label)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		dev.PowerHandler(rec, r)
+		if rec.status == http.StatusOK {
+			a.override(label)
+			if !until.IsZero() {
+				a.holds.Hold(label, until)
+				log.Printf("held until %s: %s", until.Local().Format(time.RFC3339), label)
+			}
+		}
+	case "status":
+		dev.StatusHandler(w, r)
+	case "reconcile":
+		reconcileHandler(a, label)(w, r)
+	case "hold":
+		holdHandler(a, label)(w, r)
+	case "later":
+		laterHandler(a, label)(w, r)
+	case "effect":
+		effectHandler(a, label)(w, r)
+	case "zones":
+		zonesHandler(a, label)(w, r)
+	case "matrix":
+		matrixHandler(a, label)(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "unknown device endpoint"}`))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleAway adds randomly timed on and off jobs for each away mode
+// device. Each device's events are seeded separately so that devices
+// don't switch in unison.
+func (a *app) scheduleAway(lightCron *cron.Cron, away config.Away, devices map[string]device.Device, seed int64) error {
+	// away mode may only add jitter
+	if away.Events == 0 {
+		return nil
+	}
+
+	start, err := lamplighter.ParseClock(away.Start)
+	if err != nil {
+		return fmt.Errorf("parse start: %w", err)
+	}
+
+	end, err := lamplighter.ParseClock(away.End)
+	if err != nil {
+		return fmt.Errorf("parse end: %w", err)
+	}
+
+	var transition time.Duration
+	if away.Transition != "" {
+		transition, err = time.ParseDuration(away.Transition)
+		if err != nil {
+			return fmt.Errorf("parse transition: %w", err)
+		}
+	}
+
+	on := &device.Color{
+		Brightness: uint16(away.Brightness * math.MaxUint16 / 100.0),
+		Kelvin:     uint16(away.Kelvin),
+	}
+	off := &device.Color{}
+
+	now := time.Now()
+	for _, label := range away.Devices {
+		dev, ok := devices[label]
+		if !ok {
+			log.Printf("ERR: device %q not registered, skipping away mode", label)
+			continue
+		}
+
+		hash := fnv.New64a()
+		hash.Write([]byte(label))
+
+		for i := 0; i < away.Events; i++ {
+			for index, color := range []*device.Color{on, off} {
+				schedule := lamplighter.RandomSchedule{
+					Start: start,
+					End:   end,
+					Seed:  seed ^ int64(hash.Sum64()),
+					Count: 2 * away.Events,
+					Index: 2*i + index,
+				}
+				j := Job{
+					Label:        label,
+					Device:       dev,
+					Color:        color,
+					Transition:   transition,
+					Holds:        a.holds,
+					Enabled:      true,
+					Pauses:       a.paused,
+					Connectivity: a.connectivity,
+				}
+				lightCron.Schedule(schedule, j)
+
+				log.Printf("away job: %s: %s", schedule.Next(now).Local().Format(time.RFC3339), label)
+			}
+		}
+	}
+
+	return nil
+}
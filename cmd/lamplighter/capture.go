@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+const (
+	scenesFile = "scenes.json"
+
+	// transition given to captured states when the capture request
+	// doesn't specify one
+	defaultCaptureTransition = 2 * time.Second
+)
+
+// sceneStore persists scenes captured from live device states
+type sceneStore struct {
+	path string
+
+	mu     sync.Mutex
+	scenes map[string]config.Scene
+}
+
+// newSceneStore loads captured scenes from the given path, if it exists
+func newSceneStore(path string) (*sceneStore, error) {
+	s := &sceneStore{
+		path:   path,
+		scenes: make(map[string]config.Scene),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read scenes: %w", err)
+	}
+
+	err = json.Unmarshal(b, &s.scenes)
+	if err != nil {
+		return nil, fmt.Errorf("decode scenes: %w", err)
+	}
+
+	return s, nil
+}
+
+// Merge adds captured scenes to the config. Scenes defined in the config
+// take precedence over captured scenes of the same name, and captured
+// scenes which reference devices no longer in the config are skipped.
+func (s *sceneStore) Merge(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.scenes) > 0 && cfg.Scenes == nil {
+		cfg.Scenes = make(map[string]config.Scene)
+	}
+scenes:
+	for name, scene := range s.scenes {
+		if _, ok := cfg.Scenes[name]; ok {
+			log.Printf("ERR: captured scene %q is overridden by config", name)
+			continue
+		}
+		for _, label := range scene.Devices() {
+			if _, ok := cfg.Devices[label]; !ok {
+				log.Printf("ERR: captured scene %q references missing device %q, skipping", name, label)
+				continue scenes
+			}
+		}
+		cfg.Scenes[name] = scene
+	}
+}
+
+// Get returns the captured scene with the given name
+func (s *sceneStore) Get(name string) (config.Scene, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scene, ok := s.scenes[name]
+	return scene, ok
+}
+
+// Save stores the scene under the given name and writes all captured
+// scenes to disk
+func (s *sceneStore) Save(name string, scene config.Scene) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scenes[name] = scene
+	b, err := json.MarshalIndent(s.scenes, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encode scenes: %w", err)
+	}
+
+	err = os.WriteFile(s.path, b, 0644)
+	if err != nil {
+		return fmt.Errorf("write scenes: %w", err)
+	}
+
+	return nil
+}
+
+// captureState converts a device's color into a scene state
+func captureState(color *device.Color, transition time.Duration) config.State {
+	return config.State{
+		Hue:        int(math.Round(float64(color.Hue) * 360.0 / 0x10000)),
+		Saturation: int(math.Round(float64(color.Saturation) / math.MaxUint16 * 100)),
+		Brightness: int(math.Round(float64(color.Brightness) / math.MaxUint16 * 100)),
+		Kelvin:     int(color.Kelvin),
+		Transition: transition.String(),
+	}
+}
+
+// unique returns the labels with duplicates removed, preserving order
+func unique(labels []string) []string {
+	seen := make(map[string]bool, len(labels))
+	var out []string
+	for _, label := range labels {
+		if !seen[label] {
+			seen[label] = true
+			out = append(out, label)
+		}
+	}
+	return out
+}
+
+// scenesRouter dispatches requests under /scenes/{name}
+func (a *app) scenesRouter(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/scenes/"), "/")
+	if name == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "scene name is required"}`))
+		return
+	}
+
+	switch action {
+	case "capture":
+		a.captureHandler(w, r, name)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "unknown scene endpoint"}`))
+	}
+}
+
+// captureHandler reads the current state of the selected devices and
+// saves it as a named scene. Devices are selected with the device and
+// group parameters, and default to every registered device.
+func (a *app) captureHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+		return
+	}
+
+	// captures update the running config, so they mustn't race a reload
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	cfg, devices, _ := a.snapshot()
+	// scenes from the config file can't be replaced by captures
+	captured, _ := a.scenes.Get(name)
+	if existing, ok := cfg.Scenes[name]; ok && !reflect.DeepEqual(existing, captured) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "scene is defined in config"}`))
+		return
+	}
+
+	r.ParseForm()
+	transition := defaultCaptureTransition
+	if param := r.FormValue("transition"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "unable to parse transition parameter"}`))
+			return
+		}
+		transition = parsed
+	}
+
+	labels := r.Form["device"]
+	for _, group := range r.Form["group"] {
+		members, ok := cfg.Groups[group]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": "group %q not found"}`, group)
+			return
+		}
+		labels = append(labels, members...)
+	}
+	if len(labels) == 0 {
+		for label := range devices {
+			labels = append(labels, label)
+		}
+	}
+	labels = unique(labels)
+	if len(labels) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "no devices to capture"}`))
+		return
+	}
+
+	var mu sync.Mutex
+	scene := make(config.Scene)
+	results, ok := fanOut(labels, devices, func(label string, dev device.Device) result {
+		color, err := dev.Status()
+		if err != nil {
+			log.Printf("ERR: %s: get status: %s", label, err)
+			return result{Error: "unable to query device state"}
+		}
+
+		// scenes are keyed by config label, which the bulb may not report
+		mu.Lock()
+		scene[label] = captureState(color, transition)
+		mu.Unlock()
+		return colorResult(color)
+	})
+	if !ok {
+		// don't save a scene which is missing devices
+		writeResults(w, results, ok)
+		return
+	}
+
+	err := a.scenes.Save(name, scene)
+	if err != nil {
+		log.Printf("ERR: save scene %q: %s", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "unable to save scene"}`))
+		return
+	}
+
+	// copy the config so that readers of the previous snapshot aren't
+	// affected by the new scene
+	updated := *cfg
+	updated.Scenes = make(map[string]config.Scene, len(cfg.Scenes)+1)
+	for n, s := range cfg.Scenes {
+		updated.Scenes[n] = s
+	}
+	updated.Scenes[name] = scene
+
+	a.mu.Lock()
+	a.cfg = &updated
+	a.mu.Unlock()
+
+	log.Printf("captured scene %q: %s", name, strings.Join(scene.Devices(), ", "))
+	writeResults(w, results, ok)
+}
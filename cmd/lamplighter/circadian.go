@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+
+	"github.com/robfig/cron/v3"
+)
+
+// circadian is a device's position on a sun-driven curve
+type circadian struct {
+	Curve  lamplighter.CircadianCurve
+	Pause  time.Duration
+	Pauses *pauses
+}
+
+// color returns the color of the curve at time t
+func (c *circadian) color(t time.Time) *device.Color {
+	kelvin, brightness := c.Curve.At(t)
+	return &device.Color{
+		Brightness: uint16(brightness * math.MaxUint16 / 100.0),
+		Kelvin:     kelvin,
+	}
+}
+
+// pauses records devices whose circadian curves have been paused by a
+// manual change
+type pauses struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newPauses() *pauses {
+	return &pauses{until: make(map[string]time.Time)}
+}
+
+// Pause stops the device from following its curve for the duration
+func (p *pauses) Pause(label string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until[label] = time.Now().Add(d)
+}
+
+// Paused reports whether the device's curve is paused at time t
+func (p *pauses) Paused(label string, t time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until, ok := p.until[label]
+	if ok && !t.Before(until) {
+		delete(p.until, label)
+		return false
+	}
+	return ok
+}
+
+// switchedOff reports whether the device is off. Circadian curves only
+// adjust devices which are already on, and never switch them on.
+func switchedOff(dev device.Device) (bool, error) {
+	status, err := dev.Status()
+	if err != nil {
+		return false, fmt.Errorf("get status: %w", err)
+	}
+	return status.Brightness == 0, nil
+}
+
+// scheduleCircadian adds a job for each circadian device which moves it
+// along its curve every interval. Each step transitions over the whole
+// interval, so the device changes continuously.
+func (a *app) scheduleCircadian(lightCron *cron.Cron, cfg *config.Config, devices map[string]device.Device) []error {
+	var errs []error
+	for i, c := range cfg.Circadian {
+		interval, pause, err := c.Durations()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("circadian[%d]: %w", i, err))
+			continue
+		}
+
+		curve := &circadian{
+			Curve:  c.Curve(cfg.Location),
+			Pause:  pause,
+			Pauses: a.pauses,
+		}
+
+		for _, label := range cfg.CircadianTargets(c) {
+			dev, ok := devices[label]
+			if !ok {
+				log.Printf("ERR: device %q not registered, skipping circadian curve", label)
+				continue
+			}
+
+			j := Job{
+				Label:        label,
+				Device:       dev,
+				Color:        curve.color(time.Now()),
+				Transition:   interval,
+				Circadian:    curve,
+				Holds:        a.holds,
+				Enabled:      true,
+				Pauses:       a.paused,
+				Connectivity: a.connectivity,
+			}
+			lightCron.Schedule(cron.Every(interval), j)
+			log.Printf("circadian job: every %s: %s", interval, label)
+		}
+	}
+
+	return errs
+}
+
+// circadianPause returns how long the device's curve should be paused
+// after a manual change. If the device doesn't follow a curve, ok is
+// false.
+func circadianPause(cfg *config.Config, label string) (pause time.Duration, ok bool) {
+	for _, c := range cfg.Circadian {
+		for _, target := range cfg.CircadianTargets(c) {
+			if target != label {
+				continue
+			}
+
+			_, pause, err := c.Durations()
+			if err != nil {
+				return 0, false
+			}
+			return pause, true
+		}
+	}
+
+	return 0, false
+}
+
+// override records that the device was set by hand, cancelling any
+// transition in progress and pausing its circadian curve
+func (a *app) override(label string) {
+	a.transitions.Clear(label)
+
+	cfg, _, _ := a.snapshot()
+	if pause, ok := circadianPause(cfg, label); ok {
+		a.pauses.Pause(label, pause)
+		log.Printf("paused circadian curve for %s: %s", pause, label)
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// discover prints the LIFX devices found on the local network and returns
+// the process exit code. Devices already in the config file are listed
+// under their configured labels.
+func discover(args []string) int {
+	flags := subcommandFlags("discover")
+	timeout := flags.Duration("timeout", device.DefaultDiscoveryTimeout, "How long to wait for devices to respond")
+	asJSON := flags.Bool("json", false, "Print devices as config file entries")
+	err := parseSubcommand(flags, args)
+	if err != nil {
+		return 2
+	}
+
+	configured := make(map[string]string)
+	cfg, err := config.Open(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, err)
+		return 1
+	} else if err == nil {
+		for label, d := range cfg.Devices {
+			if d.MAC != "" {
+				configured[strings.ToLower(d.MAC)] = label
+			}
+		}
+	}
+
+	discovered, err := device.DiscoverLifx(*timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "discover: %s\n", err)
+		return 1
+	}
+	if len(discovered) == 0 {
+		fmt.Fprintln(os.Stderr, "discover: no devices found")
+		return 1
+	}
+
+	label := func(d device.DiscoveredLifx) string {
+		if l, ok := configured[strings.ToLower(d.MAC)]; ok {
+			return l
+		}
+		return discoveredLabel(d)
+	}
+
+	if *asJSON {
+		devices := make(map[string]config.Device, len(discovered))
+		for _, d := range discovered {
+			devices[label(d)] = config.Device{
+				Type: string(device.TypeLifx),
+				Host: d.IP(),
+				MAC:  d.MAC,
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.Encode(map[string]interface{}{"devices": devices})
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "LABEL\tMAC\tIP\tPRODUCT")
+	for _, d := range discovered {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", label(d), d.MAC, d.IP(), d.Product)
+	}
+	return 0
+}
+
+// discoveredLabel returns the device's label, or one derived from its mac
+// address if the device has none
+func discoveredLabel(d device.DiscoveredLifx) string {
+	if d.Label != "" {
+		return d.Label
+	}
+	return "lifx-" + strings.ReplaceAll(d.MAC, ":", "")
+}
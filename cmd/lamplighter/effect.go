@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// jobEffect converts a configured effect into a device effect toward the
+// given color
+func jobEffect(effect *config.Effect, color *device.Color) (*device.Effect, error) {
+	waveform, err := device.ParseWaveform(effect.Waveform)
+	if err != nil {
+		return nil, err
+	}
+
+	period, cycles, skew, err := effect.Parameters()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.Effect{
+		Waveform: waveform,
+		Color:    color,
+		Period:   period,
+		Cycles:   cycles,
+		Skew:     skew,
+		Persist:  effect.Persist,
+	}, nil
+}
+
+// runEffect runs the job's effect on its device
+func (j Job) runEffect() {
+	dev, ok := j.Device.(device.Effector)
+	if !ok {
+		log.Printf("ERR: device does not support effects: %s", j.Device.Label())
+		return
+	}
+
+	log.Printf(`{"device": %q, "effect": %q, "period": %q, "cycles": %g}`, j.Device.Label(), j.Effect.Waveform, j.Effect.Period, j.Effect.Cycles)
+	// persistent effects replace any transition in progress, and transient
+	// ones interrupt it until they finish
+	if j.Tracker != nil {
+		if j.Effect.Persist {
+			j.Tracker.Clear(j.Label)
+		} else {
+			j.Tracker.Pause(j.Label, time.Now().Add(j.Effect.Duration()))
+		}
+	}
+
+	err := dev.Effect(j.Effect)
+	if err != nil {
+		log.Printf("ERR: run effect: %s", err)
+		return
+	}
+	j.Connectivity.Seen(j.Label, time.Now())
+}
+
+// effectHandler runs a waveform effect on the device. Effects which
+// persist count as manual changes; transient effects leave the device as
+// it was.
+func effectHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		_, devices, _ := a.snapshot()
+		dev, ok := devices[label].(device.Effector)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "device does not support effects"}`))
+			return
+		}
+
+		effect, err := device.ParseEffectParams(r)
+		if err != nil {
+			device.WriteParamError(w, label, err)
+			return
+		}
+
+		if effect.Persist {
+			a.transitions.Clear(label)
+		} else {
+			a.transitions.Pause(label, time.Now().Add(effect.Duration()))
+		}
+		err = dev.Effect(effect)
+		if err != nil {
+			log.Printf("ERR: effect: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "unable to run effect on device"}`))
+			return
+		}
+		if effect.Persist {
+			a.override(label)
+		}
+
+		fmt.Fprintf(
+			w,
+			`{"waveform": %q, "hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d, "period": %q, "cycles": %g, "persist": %t}`,
+			effect.Waveform,
+			float64(effect.Color.Hue)*360.0/0x10000,
+			float64(effect.Color.Saturation)/math.MaxUint16*100,
+			float64(effect.Color.Brightness)/math.MaxUint16*100,
+			effect.Color.Kelvin,
+			effect.Period,
+			effect.Cycles,
+			effect.Persist,
+		)
+	})
+}
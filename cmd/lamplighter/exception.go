@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/calendar"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultPreviewDays = 30
+
+	// limits the number of firings checked per entry when previewing
+	// exceptions, such as for circadian entries
+	maxPreviewFirings = 10000
+)
+
+// exception skips or replaces a job for one device on days with
+// matching calendar events
+type exception struct {
+	Calendar string
+	Events   *calendar.Calendar
+	Match    string
+
+	// Scene replaces the job, setting the device to Color over
+	// Transition. If Color is nil, the job is skipped.
+	Scene      string
+	Color      *device.Color
+	Transition time.Duration
+}
+
+// newExceptions builds the job's exceptions for the labelled device. If
+// a replacement scene doesn't include the device, the device's job is
+// skipped instead.
+func newExceptions(cfg *config.Config, job config.Job, label string, calendars map[string]*calendar.Calendar) []exception {
+	var exceptions []exception
+	for _, e := range job.Exceptions {
+		events, ok := calendars[e.Calendar]
+		if !ok {
+			continue
+		}
+
+		exc := exception{
+			Calendar: e.Calendar,
+			Events:   events,
+			Match:    e.Match,
+			Scene:    e.Scene,
+		}
+		if state, ok := cfg.Scenes[e.Scene][label]; ok {
+			color, transition, err := stateColor(state)
+			if err != nil {
+				log.Printf("ERR: %s: exception scene %q: %s", label, e.Scene, err)
+				continue
+			}
+			exc.Color, exc.Transition = color, transition
+		}
+
+		exceptions = append(exceptions, exc)
+	}
+
+	return exceptions
+}
+
+// Except returns the job as it runs at time t, replaced by the first
+// exception with a matching calendar event. If the exception skips the
+// job, ok is false.
+func (j Job) Except(t time.Time) (job Job, ok bool) {
+	for _, exc := range j.Exceptions {
+		event, matched := exc.Events.On(t, exc.Match)
+		if !matched {
+			continue
+		}
+
+		j.Event = event.Summary
+		if exc.Color == nil {
+			return j, false
+		}
+
+		j.Scene = exc.Scene
+		j.Effect = nil
+		j.Zones = nil
+		j.Frame = nil
+		j.MatrixEffect = nil
+		j.Color = exc.Color
+		j.Transition = exc.Transition
+		return j, true
+	}
+
+	return j, true
+}
+
+// Suppressed describes a scheduled firing which an exception skips or
+// replaces
+type Suppressed struct {
+	Entry  cron.EntryID `json:"entry"`
+	Job    string       `json:"job,omitempty"`
+	Time   string       `json:"time"`
+	Device string       `json:"device"`
+	Event  string       `json:"event"`
+	Action string       `json:"action"` // skip or replace
+	Scene  string       `json:"scene,omitempty"`
+}
+
+// exceptionHandler previews the firings over the coming days which
+// exceptions would skip or replace. The number of days defaults to 30
+// and can be set with the days parameter.
+func exceptionHandler(a *app) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		days := defaultPreviewDays
+		if param := r.URL.Query().Get("days"); param != "" {
+			d, err := strconv.Atoi(param)
+			if err != nil || d < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "unable to parse days parameter"}`))
+				return
+			}
+			days = d
+		}
+
+		_, _, lightCron := a.snapshot()
+		now := time.Now()
+		end := now.AddDate(0, 0, days)
+
+		suppressed := []Suppressed{}
+		for _, entry := range lightCron.Entries() {
+			job, ok := entry.Job.(Job)
+			if !ok || len(job.Exceptions) == 0 {
+				continue
+			}
+
+			t := entry.Schedule.Next(now)
+			for i := 0; i < maxPreviewFirings && !t.IsZero() && t.Before(end); i++ {
+				excepted, ok := job.Except(t)
+				if excepted.Event != "" {
+					s := Suppressed{
+						Entry:  entry.ID,
+						Job:    job.ID,
+						Time:   t.Local().Format(time.RFC3339),
+						Device: job.Label,
+						Event:  excepted.Event,
+						Action: "replace",
+						Scene:  excepted.Scene,
+					}
+					if !ok {
+						s.Action = "skip"
+						s.Scene = ""
+					}
+					suppressed = append(suppressed, s)
+				}
+				t = entry.Schedule.Next(t)
+			}
+		}
+
+		err := json.NewEncoder(w).Encode(suppressed)
+		if err != nil {
+			log.Printf("ERR: write exceptions: %s", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// result is the outcome of an action on a single device within a group
+type result struct {
+	Hue        *float64 `json:"hue,omitempty"`
+	Saturation *float64 `json:"saturation,omitempty"`
+	Brightness *float64 `json:"brightness,omitempty"`
+	Kelvin     *uint16  `json:"kelvin,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// colorResult converts a device color into a result using the units
+// accepted by the HTTP handlers
+func colorResult(color *device.Color) result {
+	hue := float64(color.Hue) * 360.0 / 0x10000
+	saturation := float64(color.Saturation) / math.MaxUint16 * 100
+	brightness := float64(color.Brightness) / math.MaxUint16 * 100
+	kelvin := color.Kelvin
+
+	return result{
+		Hue:        &hue,
+		Saturation: &saturation,
+		Brightness: &brightness,
+		Kelvin:     &kelvin,
+	}
+}
+
+// fanOut runs fn concurrently for each of the labelled devices and
+// collects the results by label. fn is passed the device's config label,
+// which the device may report differently. Labels which aren't
+// registered are reported as errors. The returned bool is false if any
+// device failed.
+func fanOut(labels []string, devices map[string]device.Device, fn func(string, device.Device) result) (map[string]result, bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]result, len(labels))
+	ok := true
+
+	for _, label := range labels {
+		dev, registered := devices[label]
+		if !registered {
+			results[label] = result{Error: "device not registered"}
+			ok = false
+			continue
+		}
+
+		wg.Add(1)
+		go func(label string, dev device.Device) {
+			defer wg.Done()
+			res := fn(label, dev)
+
+			mu.Lock()
+			results[label] = res
+			if res.Error != "" {
+				ok = false
+			}
+			mu.Unlock()
+		}(label, dev)
+	}
+	wg.Wait()
+
+	return results, ok
+}
+
+// writeResults encodes per-device results, responding with an error
+// status if any device failed
+func writeResults(w http.ResponseWriter, results map[string]result, ok bool) {
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	err := json.NewEncoder(w).Encode(map[string]interface{}{"devices": results})
+	if err != nil {
+		log.Printf("ERR: write results: %s", err)
+	}
+}
+
+// groupRouter dispatches requests under /group/{name} to every device in
+// the group
+func (a *app) groupRouter(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/group/"), "/")
+
+	cfg, devices, _ := a.snapshot()
+	labels, ok := cfg.Groups[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "group not found"}`))
+		return
+	}
+
+	switch action {
+	case "":
+		a.groupPowerHandler(w, r, name, labels, devices)
+	case "status":
+		results, ok := fanOut(labels, devices, func(label string, dev device.Device) result {
+			color, err := dev.Status()
+			if err != nil {
+				log.Printf("ERR: %s: get status: %s", label, err)
+				return result{Error: "unable to query device state"}
+			}
+			return colorResult(color)
+		})
+		writeResults(w, results, ok)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "unknown group endpoint"}`))
+	}
+}
+
+// groupPowerHandler transitions every device in the group to the
+// requested color concurrently
+func (a *app) groupPowerHandler(w http.ResponseWriter, r *http.Request, name string, labels []string, devices map[string]device.Device) {
+	color, transition, err := device.ParseColorParams(r)
+	if err != nil {
+		device.WriteParamError(w, name, err)
+		return
+	}
+
+	results, ok := fanOut(labels, devices, func(label string, dev device.Device) result {
+		err := dev.Transition(color, transition)
+		if err != nil {
+			log.Printf("ERR: %s: transition: %s", label, err)
+			return result{Error: fmt.Sprintf("unable to set brightness on device: %s", err)}
+		}
+		a.override(label)
+		return colorResult(color)
+	})
+	writeResults(w, results, ok)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+)
+
+const (
+	holdsFile = "holds.json"
+
+	// holdUntilPrefix introduces a hold which lasts until a solar event,
+	// such as "until-next-sunrise"
+	holdUntilPrefix = "until-next-"
+)
+
+// holds records devices whose scheduled jobs are suspended until a
+// deadline. Holds are persisted so that they survive a restart.
+type holds struct {
+	path string
+
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// newHolds loads holds from the given path, if it exists
+func newHolds(path string) (*holds, error) {
+	h := &holds{
+		path:  path,
+		until: make(map[string]time.Time),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read holds: %w", err)
+	}
+
+	err = json.Unmarshal(b, &h.until)
+	if err != nil {
+		return nil, fmt.Errorf("decode holds: %w", err)
+	}
+
+	return h, nil
+}
+
+// Hold suspends the device's jobs until the given time
+func (h *holds) Hold(label string, until time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.until[label] = until
+	h.save()
+}
+
+// Release cancels the device's hold, returning false if it had none
+func (h *holds) Release(label string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.until[label]
+	if ok {
+		delete(h.until, label)
+		h.save()
+	}
+	return ok
+}
+
+// Until returns the end of the device's hold if it's held at time t.
+// Holds which have ended are left for Expire to remove.
+func (h *holds) Until(label string, t time.Time) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	until, ok := h.until[label]
+	if !ok || !t.Before(until) {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// Expire removes holds which have ended by time t
+func (h *holds) Expire(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expired := false
+	for label, until := range h.until {
+		if !t.Before(until) {
+			delete(h.until, label)
+			expired = true
+		}
+	}
+	if expired {
+		h.save()
+	}
+}
+
+// Held reports whether the device is held at time t
+func (h *holds) Held(label string, t time.Time) bool {
+	_, ok := h.Until(label, t)
+	return ok
+}
+
+// save writes holds to disk. The caller must hold h.mu.
+func (h *holds) save() {
+	b, err := json.Marshal(h.until)
+	if err != nil {
+		log.Printf("ERR: encode holds: %s", err)
+		return
+	}
+
+	err = os.WriteFile(h.path, b, 0644)
+	if err != nil {
+		log.Printf("ERR: write holds: %s", err)
+	}
+}
+
+// parseHold parses a hold parameter, which is either a duration, such as
+// "2h", or "until-next-" followed by a solar event, such as
+// "until-next-sunrise", and returns the time at which the hold ends
+func parseHold(s string, location lamplighter.Location, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, holdUntilPrefix) {
+		event := strings.TrimPrefix(s, holdUntilPrefix)
+		schedule, err := lamplighter.Parser{Location: location}.Parse("@" + event)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse hold event: %w", err)
+		}
+
+		until := schedule.Next(now)
+		if until.IsZero() {
+			return time.Time{}, fmt.Errorf("hold event %q does not occur", event)
+		}
+		return until, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse hold duration: %w", err)
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("hold duration must be positive")
+	}
+
+	return now.Add(d), nil
+}
+
+// holdHandler cancels the device's hold
+func holdHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		if !a.holds.Release(label) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "device is not held"}`))
+			return
+		}
+
+		log.Printf("released hold: %s", label)
+		w.Write([]byte(`{"status": "released"}`))
+	})
+}
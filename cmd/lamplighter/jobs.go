@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+
+	"github.com/robfig/cron/v3"
+)
+
+const jobsFile = "jobs.json"
+
+// jobStore persists jobs created through the HTTP API
+type jobStore struct {
+	path string
+
+	mu   sync.Mutex
+	jobs []config.Job
+}
+
+// newJobStore loads API jobs from the given path, if it exists
+func newJobStore(path string) (*jobStore, error) {
+	s := &jobStore{path: path}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read jobs: %w", err)
+	}
+
+	err = json.Unmarshal(b, &s.jobs)
+	if err != nil {
+		return nil, fmt.Errorf("decode jobs: %w", err)
+	}
+
+	return s, nil
+}
+
+// Jobs returns a copy of the stored jobs
+func (s *jobStore) Jobs() []config.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]config.Job(nil), s.jobs...)
+}
+
+// Replace stores the given jobs and writes them to disk
+func (s *jobStore) Replace(jobs []config.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobs == nil {
+		jobs = []config.Job{}
+	}
+	b, err := json.MarshalIndent(jobs, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encode jobs: %w", err)
+	}
+
+	err = os.WriteFile(s.path, b, 0644)
+	if err != nil {
+		return fmt.Errorf("write jobs: %w", err)
+	}
+
+	s.jobs = jobs
+	return nil
+}
+
+// Merge appends API jobs to the config's jobs
+func (s *jobStore) Merge(cfg *config.Config) {
+	mergeJobs(cfg, s.Jobs())
+}
+
+// mergeJobs appends the API jobs to the config's jobs, marking them as
+// API jobs. API jobs whose IDs clash with config jobs, or which are no
+// longer valid against the config, are skipped.
+func mergeJobs(cfg *config.Config, jobs []config.Job) {
+	ids := make(map[string]bool, len(cfg.Jobs))
+	for _, job := range cfg.Jobs {
+		ids[job.ID] = true
+	}
+
+	for _, job := range jobs {
+		if ids[job.ID] {
+			log.Printf("ERR: job %q is overridden by config", job.ID)
+			continue
+		}
+
+		err := cfg.ValidateJob(job)
+		if err != nil {
+			log.Printf("ERR: job %q is invalid, skipping: %s", job.ID, err)
+			continue
+		}
+
+		job.API = true
+		ids[job.ID] = true
+		cfg.Jobs = append(cfg.Jobs, job)
+	}
+}
+
+// JobInfo describes a job along with where it was defined and the cron
+// entries scheduled for it
+type JobInfo struct {
+	config.Job
+	Source  string         `json:"source"` // config or api
+	Entries []cron.EntryID `json:"entries"`
+	Paused  bool           `json:"paused"`
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobInfo lists the running jobs with their cron entry IDs
+func (a *app) jobInfo() []JobInfo {
+	cfg, _, lightCron := a.snapshot()
+
+	entries := make(map[string][]cron.EntryID)
+	for _, entry := range lightCron.Entries() {
+		if job, ok := entry.Job.(Job); ok && job.ID != "" {
+			entries[job.ID] = append(entries[job.ID], entry.ID)
+		}
+	}
+
+	info := make([]JobInfo, 0, len(cfg.Jobs))
+	for _, job := range cfg.Jobs {
+		source := "config"
+		if job.API {
+			source = "api"
+		}
+
+		info = append(info, JobInfo{
+			Job:     job,
+			Source:  source,
+			Entries: entries[job.ID],
+			Paused:  a.paused.Paused(job.ID, job.IsEnabled()),
+		})
+	}
+
+	return info
+}
+
+// updateJobs applies fn to the API jobs and, if the resulting config is
+// valid, replaces the running config and persists the API jobs
+func (a *app) updateJobs(fn func([]config.Job) ([]config.Job, error)) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	jobs, err := fn(a.jobs.Jobs())
+	if err != nil {
+		return err
+	}
+
+	current, _, _ := a.snapshot()
+	cfg := *current
+	cfg.Jobs = nil
+	for _, job := range current.Jobs {
+		if !job.API {
+			cfg.Jobs = append(cfg.Jobs, job)
+		}
+	}
+	mergeJobs(&cfg, jobs)
+
+	err = a.apply(&cfg)
+	if err != nil {
+		return err
+	}
+
+	return a.jobs.Replace(jobs)
+}
+
+// addJob validates the API job, persists it, and adds it to the running
+// cron. Unlike updateJobs, the cron isn't rebuilt, so adding a job doesn't
+// disturb the others.
+func (a *app) addJob(job config.Job) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	current, devices, lightCron := a.snapshot()
+	err := current.ValidateJob(job)
+	if err != nil {
+		return err
+	}
+
+	calendars, err := current.OpenCalendars()
+	if err != nil {
+		return err
+	}
+
+	cfg := *current
+	cfg.Jobs = append(append([]config.Job(nil), current.Jobs...), job)
+	cfg.Jobs[len(cfg.Jobs)-1].API = true
+
+	// entries are built on a scratch cron so that nothing is scheduled
+	// unless the whole job is
+	scratch := cron.New()
+	errs := a.scheduleJob(scratch, &cfg, len(cfg.Jobs)-1, devices, calendars, a.seed, time.Now())
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid job: %w", errors.Join(errs...))
+	}
+
+	err = a.jobs.Replace(append(a.jobs.Jobs(), job))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range scratch.Entries() {
+		lightCron.Schedule(entry.Schedule, entry.Job)
+	}
+
+	a.mu.Lock()
+	a.cfg = &cfg
+	a.mu.Unlock()
+
+	return nil
+}
+
+// jobError is returned by job updates which conflict with existing jobs
+type jobError struct {
+	status int
+	msg    string
+}
+
+func (e *jobError) Error() string {
+	return e.msg
+}
+
+// writeJobError responds with the status of a jobError, or with a bad
+// request for any other error, such as a validation error
+func writeJobError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var jobErr *jobError
+	if errors.As(err, &jobErr) {
+		status = jobErr.status
+	}
+
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error": %q}`, err.Error())
+}
+
+// jobsRouter serves /jobs and /jobs/{id}
+func (a *app) jobsRouter(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+
+	switch {
+	case id == "":
+		a.jobsHandler(w, r)
+	case action == "":
+		a.jobHandler(w, r, id)
+	case action == "pause":
+		pauseJobHandler(a, id, false)(w, r)
+	case action == "resume":
+		pauseJobHandler(a, id, true)(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "unknown job endpoint"}`))
+	}
+}
+
+// jobsHandler lists jobs and creates new jobs
+func (a *app) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		err := json.NewEncoder(w).Encode(a.jobInfo())
+		if err != nil {
+			log.Printf("ERR: write jobs: %s", err)
+		}
+	case http.MethodPost:
+		var job config.Job
+		err := json.NewDecoder(r.Body).Decode(&job)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("decode job: %s", err))
+			return
+		}
+		if job.ID == "" {
+			job.ID = newJobID()
+		}
+
+		err = a.updateJobs(func(jobs []config.Job) ([]config.Job, error) {
+			// stored API jobs may be shadowed by config jobs, so both
+			// are checked
+			cfg, _, _ := a.snapshot()
+			for _, existing := range [][]config.Job{cfg.Jobs, jobs} {
+				for _, e := range existing {
+					if e.ID == job.ID {
+						return nil, &jobError{status: http.StatusConflict, msg: fmt.Sprintf("job %q already exists", job.ID)}
+					}
+				}
+			}
+			if err := cfg.ValidateJob(job); err != nil {
+				return nil, err
+			}
+			return append(jobs, job), nil
+		})
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+
+		log.Printf("created job: %s", job.ID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(job)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+	}
+}
+
+// jobHandler gets, replaces, or deletes a single job. Only jobs created
+// through the API can be changed.
+func (a *app) jobHandler(w http.ResponseWriter, r *http.Request, id string) {
+	// index returns the position of the job among the API jobs
+	index := func(jobs []config.Job) (int, error) {
+		for i, job := range jobs {
+			if job.ID == id {
+				return i, nil
+			}
+		}
+
+		cfg, _, _ := a.snapshot()
+		for _, job := range cfg.Jobs {
+			if job.ID == id {
+				return 0, &jobError{status: http.StatusConflict, msg: "job is defined in config"}
+			}
+		}
+		return 0, &jobError{status: http.StatusNotFound, msg: "job not found"}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		for _, info := range a.jobInfo() {
+			if info.ID == id {
+				json.NewEncoder(w).Encode(info)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "job not found"}`))
+	case http.MethodPut:
+		var job config.Job
+		err := json.NewDecoder(r.Body).Decode(&job)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("decode job: %s", err))
+			return
+		}
+		job.ID = id
+
+		err = a.updateJobs(func(jobs []config.Job) ([]config.Job, error) {
+			i, err := index(jobs)
+			if err != nil {
+				return nil, err
+			}
+
+			cfg, _, _ := a.snapshot()
+			if err := cfg.ValidateJob(job); err != nil {
+				return nil, err
+			}
+
+			jobs[i] = job
+			return jobs, nil
+		})
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+
+		log.Printf("updated job: %s", id)
+		json.NewEncoder(w).Encode(job)
+	case http.MethodDelete:
+		err := a.updateJobs(func(jobs []config.Job) ([]config.Job, error) {
+			i, err := index(jobs)
+			if err != nil {
+				return nil, err
+			}
+			return append(jobs[:i], jobs[i+1:]...), nil
+		})
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+
+		log.Printf("deleted job: %s", id)
+		w.Write([]byte(`{"status": "deleted"}`))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// sweepInterval is how often expired one-shot and dated jobs are removed
+const sweepInterval = time.Minute
+
+// laterHandler schedules a one-shot job which sets the device to the
+// requested color at a later time. The at parameter is either a delay,
+// such as "45m", or a time, such as "2026-12-24T17:00".
+func laterHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		now := time.Now()
+		at, err := parseLater(r.URL.Query().Get("at"), now)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, err.Error())
+			return
+		}
+
+		color, transition, err := device.ParseColorParams(r)
+		if err != nil {
+			device.WriteParamError(w, label, err)
+			return
+		}
+
+		job := config.Job{
+			ID:         newJobID(),
+			Schedule:   fmt.Sprintf("%s %s", lamplighter.AtPrefix, at.Local().Format("2006-01-02T15:04:05")),
+			Device:     label,
+			Hue:        int(math.Round(float64(color.Hue) * 360.0 / 0x10000)),
+			Saturation: int(math.Round(float64(color.Saturation) / math.MaxUint16 * 100)),
+			Brightness: int(math.Round(float64(color.Brightness) / math.MaxUint16 * 100)),
+			Kelvin:     int(color.Kelvin),
+			Transition: transition.String(),
+		}
+
+		err = a.addJob(job)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+
+		log.Printf("scheduled job %s: %s: %s", job.ID, at.Local().Format(time.RFC3339), label)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(job)
+	})
+}
+
+// parseLater parses a delay or time of day at which a one-shot job runs
+func parseLater(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("at parameter is required")
+	}
+
+	var at time.Time
+	if d, err := time.ParseDuration(s); err == nil {
+		at = now.Add(d)
+	} else {
+		at, err = lamplighter.ParseAt(s)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if !at.After(now) {
+		return time.Time{}, fmt.Errorf("at must be in the future")
+	}
+	return at, nil
+}
+
+// sweepExpired periodically removes cron entries and API jobs which will
+// never fire again, such as one-shot jobs which have already run, along
+// with device holds which have ended
+func (a *app) sweepExpired(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cfg, _, lightCron := a.snapshot()
+		for _, entry := range lightCron.Entries() {
+			if entry.Next.IsZero() {
+				lightCron.Remove(entry.ID)
+			}
+		}
+
+		now := time.Now()
+		a.holds.Expire(now)
+
+		expired := make(map[string]bool)
+		for _, job := range a.jobs.Jobs() {
+			schedule, err := job.ParseSchedule(cfg.Location)
+			if err != nil {
+				continue
+			}
+
+			// jitter may shift the final event past now
+			var window time.Duration
+			if job.Jitter != "" {
+				window, _ = time.ParseDuration(job.Jitter)
+			}
+			if schedule.Next(now.Add(-window)).IsZero() {
+				expired[job.ID] = true
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		err := a.updateJobs(func(jobs []config.Job) ([]config.Job, error) {
+			var remaining []config.Job
+			for _, job := range jobs {
+				if !expired[job.ID] {
+					remaining = append(remaining, job)
+				}
+			}
+			return remaining, nil
+		})
+		if err != nil {
+			log.Printf("ERR: remove expired jobs: %s", err)
+			continue
+		}
+		log.Printf("removed %d expired jobs", len(expired))
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/subtlepseudonym/lamplighter"
 	"github.com/subtlepseudonym/lamplighter/config"
 	"github.com/subtlepseudonym/lamplighter/device"
+	"github.com/subtlepseudonym/lamplighter/frame"
 
 	"github.com/robfig/cron/v3"
 )
@@ -21,23 +23,99 @@ import (
 const (
 	DefaultConfigPath = "config/lamp.cfg"
 
-	listenAddr    = ":9000"
-	sunrisePrefix = "@sunrise"
-	sunsetPrefix  = "@sunset"
+	listenAddr = ":9000"
 )
 
 var (
 	safe       bool // safe startup
+	catchUp    bool // reconcile device states on startup
 	configPath string
+	stateDir   string
+
+	watchInterval time.Duration
 )
 
 type Job struct {
-	Device     device.Device
-	Color      *device.Color
-	Transition time.Duration
+	ID           string // config.Job ID, empty for away and circadian jobs
+	Device       device.Device
+	Label        string // the device's config label, which it may report differently
+	Group        string // set if the job was scheduled for a group
+	Scene        string // set if the job was scheduled for a scene
+	Color        *device.Color
+	Transition   time.Duration
+	Tracker      *tracker
+	Circadian    *circadian // if set, Color is taken from the curve
+	Holds        *holds
+	Enabled      bool
+	Pauses       *pauseState
+	Exceptions   []exception
+	Event        string               // calendar event which replaced or skipped the job
+	Effect       *device.Effect       // if set, run instead of transitioning to Color
+	Zones        *device.ZonePattern  // if set, applied instead of Color
+	Frame        *frame.Frame         // if set, shown dimmed to Color's brightness
+	MatrixEffect *device.MatrixEffect // if set, run instead of transitioning to Color
+	Connectivity *connectivity        // records that the device responded
+}
+
+// Paused reports whether the job is paused, either by itself or because
+// all scheduling is paused
+func (j Job) Paused() bool {
+	return j.Pauses != nil && j.Pauses.Paused(j.ID, j.Enabled)
+}
+
+// At returns the job with its color as of time t. Circadian jobs take the
+// curve's color at the end of their transition, so that the device
+// arrives on the curve rather than trailing it by one interval.
+func (j Job) At(t time.Time) Job {
+	if j.Circadian != nil {
+		j.Color = j.Circadian.color(t.Add(j.Transition))
+	}
+	return j
 }
 
 func (j Job) Run() {
+	if j.Paused() {
+		log.Printf("job paused, skipping: %s", j.Device.Label())
+		return
+	}
+
+	now := time.Now()
+	if j.Holds != nil && j.Holds.Held(j.Label, now) {
+		log.Printf("device held, skipping job: %s", j.Label)
+		return
+	}
+	if j.Circadian != nil && j.Circadian.Pauses.Paused(j.Label, now) {
+		log.Printf("circadian curve paused, skipping job: %s", j.Label)
+		return
+	}
+	if j.Circadian != nil {
+		off, err := switchedOff(j.Device)
+		if err != nil {
+			log.Printf("ERR: circadian job: %s", err)
+			return
+		}
+		if off {
+			log.Printf("device off, skipping circadian job: %s", j.Device.Label())
+			return
+		}
+	}
+	j = j.At(now)
+
+	j, ok := j.Except(now)
+	if !ok {
+		log.Printf("skipping job for calendar event %q: %s", j.Event, j.Device.Label())
+		return
+	}
+
+	if j.Effect != nil {
+		j.runEffect()
+		return
+	}
+	if j.MatrixEffect != nil {
+		j.runMatrixEffect()
+		return
+	}
+
 	log.Printf(
 		`{"device": %q, "hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d, "transition": %q}`,
 		j.Device.Label(),
@@ -48,41 +126,86 @@ func (j Job) Run() {
 		j.Transition,
 	)
 
-	err := j.Device.Transition(j.Color, j.Transition)
+	var err error
+	if j.Frame != nil {
+		err = j.setFrame()
+	} else if j.Zones != nil {
+		err = j.setZones()
+	} else if j.Tracker != nil {
+		err = j.Tracker.Transition(j.Label, j.Device, j.Color, j.Transition)
+	} else {
+		err = j.Device.Transition(j.Color, j.Transition)
+	}
 	if err != nil {
 		log.Printf("ERR: transition device: %s", err)
+		return
 	}
+	j.Connectivity.Seen(j.Label, time.Now())
 }
 
 type DeviceInfo struct {
-	Type   string `json:"type"`
-	Device string `json:"device"`
-	MAC    string `json:"mac"`
+	Type      string `json:"type"`
+	Device    string `json:"device,omitempty"` // empty until the device connects
+	MAC       string `json:"mac"`
+	Status    string `json:"status"` // online or offline
+	LastSeen  string `json:"last_seen,omitempty"`
+	HeldUntil string `json:"held_until,omitempty"`
 }
 
 type Entry struct {
-	Next       string  `json:"next"`
-	Device     string  `json:"device"`
-	Hue        float64 `json:"hue"`
-	Saturation float64 `json:"saturation"`
-	Brightness float64 `json:"brightness"`
-	Kelvin     uint16  `json:"kelvin"`
-	Transition string  `json:"transition"`
+	ID           cron.EntryID `json:"id"`
+	Job          string       `json:"job,omitempty"`
+	Next         string       `json:"next"`
+	Device       string       `json:"device"`
+	Group        string       `json:"group,omitempty"`
+	Scene        string       `json:"scene,omitempty"`
+	Circadian    bool         `json:"circadian,omitempty"`
+	Hue          float64      `json:"hue"`
+	Saturation   float64      `json:"saturation"`
+	Brightness   float64      `json:"brightness"`
+	Kelvin       uint16       `json:"kelvin"`
+	Transition   string       `json:"transition"`
+	Fallback     bool         `json:"fallback,omitempty"`
+	Held         bool         `json:"held,omitempty"`
+	Paused       bool         `json:"paused,omitempty"`
+	Exception    string       `json:"exception,omitempty"`
+	Skipped      bool         `json:"skipped,omitempty"`
+	Effect       string       `json:"effect,omitempty"`
+	Zones        bool         `json:"zones,omitempty"`
+	Frame        bool         `json:"frame,omitempty"`
+	MatrixEffect string       `json:"matrix_effect,omitempty"`
 }
 
-func deviceHandler(configured map[string]config.Device, registered map[string]device.Device) http.HandlerFunc {
+func deviceHandler(a *app) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		headers := w.Header()
 		headers.Add("Access-Control-Allow-Origin", "*")
 
+		cfg, registered, _ := a.snapshot()
+		configured := cfg.Devices
+
+		// configured devices which haven't connected are listed as offline
 		info := make(map[string]DeviceInfo)
-		for label, device := range registered {
-			cfgDevice := configured[label]
-			info[label] = DeviceInfo{
+		for label, cfgDevice := range configured {
+			i := DeviceInfo{
 				Type:   cfgDevice.Type,
-				Device: device.String(),
 				MAC:    cfgDevice.MAC,
+				Status: "offline",
+			}
+			if device, ok := registered[label]; ok {
+				i.Device = device.String()
+			}
+			online, lastSeen := a.connectivity.Status(label)
+			if online {
+				i.Status = "online"
 			}
+			if !lastSeen.IsZero() {
+				i.LastSeen = lastSeen.Local().Format(time.RFC3339)
+			}
+			if until, ok := a.holds.Until(label, time.Now()); ok {
+				i.HeldUntil = until.Local().Format(time.RFC3339)
+			}
+			info[label] = i
 		}
 
 		b, err := json.Marshal(info)
@@ -93,8 +216,16 @@ func deviceHandler(configured map[string]config.Device, registered map[string]de
 	})
 }
 
-func entryHandler(lightCron *cron.Cron) http.HandlerFunc {
+func entryHandler(a *app) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Has("from") || query.Has("to") {
+			simulationHandler(a, w, r)
+			return
+		}
+
+		_, _, lightCron := a.snapshot()
+
 		var entries []Entry
 		for _, entry := range lightCron.Entries() {
 			job, ok := entry.Job.(Job)
@@ -103,16 +234,42 @@ func entryHandler(lightCron *cron.Cron) http.HandlerFunc {
 				continue
 			}
 
-			entry := Entry{
-				Next:       entry.Schedule.Next(time.Now()).Local().Format(time.RFC3339),
-				Device:     job.Device.Label(),
+			now := time.Now()
+			next := entry.Schedule.Next(now)
+			job = job.At(next)
+			job, run := job.Except(next)
+			e := Entry{
+				ID:         entry.ID,
+				Job:        job.ID,
+				Next:       next.Local().Format(time.RFC3339),
+				Device:     job.Label,
+				Group:      job.Group,
+				Scene:      job.Scene,
+				Circadian:  job.Circadian != nil,
+				Paused:     job.Paused(),
 				Hue:        float64(job.Color.Hue) * 360.0 / 0x10000,
 				Saturation: float64(job.Color.Saturation) / math.MaxUint16 * 100,
 				Brightness: float64(job.Color.Brightness) / math.MaxUint16 * 100,
 				Kelvin:     job.Color.Kelvin,
 				Transition: job.Transition.String(),
+				Exception:  job.Event,
+				Skipped:    !run,
+			}
+			if job.Effect != nil {
+				e.Effect = string(job.Effect.Waveform)
 			}
-			entries = append(entries, entry)
+			e.Zones = job.Zones != nil
+			e.Frame = job.Frame != nil
+			if job.MatrixEffect != nil {
+				e.MatrixEffect = string(job.MatrixEffect.Type)
+			}
+			if schedule, ok := entry.Schedule.(lamplighter.FallbackSchedule); ok {
+				e.Fallback = schedule.IsFallback(now)
+			}
+			if until, ok := a.holds.Until(e.Device, now); ok && next.Before(until) {
+				e.Held = true
+			}
+			entries = append(entries, e)
 		}
 
 		err := json.NewEncoder(w).Encode(entries)
@@ -126,9 +283,80 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// loadState reads the persistent state in the state directory, merging
+// stored scenes and API jobs into the config, and returns an app holding
+// it. The daemon and its subcommands all load state this way, so they
+// see the same jobs.
+func loadState(cfg *config.Config) (*app, error) {
+	scenes, err := newSceneStore(filepath.Join(stateDir, scenesFile))
+	if err != nil {
+		return nil, fmt.Errorf("load scenes: %w", err)
+	}
+	scenes.Merge(cfg)
+
+	jobs, err := newJobStore(filepath.Join(stateDir, jobsFile))
+	if err != nil {
+		return nil, fmt.Errorf("load jobs: %w", err)
+	}
+	jobs.Merge(cfg)
+
+	paused, err := newPauseState(filepath.Join(stateDir, pausedFile))
+	if err != nil {
+		return nil, fmt.Errorf("load paused jobs: %w", err)
+	}
+
+	holds, err := newHolds(filepath.Join(stateDir, holdsFile))
+	if err != nil {
+		return nil, fmt.Errorf("load holds: %w", err)
+	}
+
+	// without a configured seed, jitter differs on every start
+	seed := time.Now().UnixNano()
+	if cfg.Seed != nil {
+		seed = *cfg.Seed
+	}
+
+	return &app{
+		cfg:    cfg,
+		seed:   seed,
+		scenes: scenes,
+		jobs:   jobs,
+		pauses: newPauses(),
+		holds:  holds,
+		paused: paused,
+	}, nil
+}
+
+// subcommandFlags returns the flag set for the named subcommand. Like the
+// daemon, every subcommand accepts -config and -state, which may be given
+// either before or after the subcommand name.
+func subcommandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", configPath, "Path to config file")
+	flags.StringVar(&stateDir, "state", stateDir, "Directory for persistent state (default: the config file's directory)")
+	return flags
+}
+
+// parseSubcommand parses the subcommand's arguments and defaults the state
+// directory to the config file's directory
+func parseSubcommand(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if stateDir == "" {
+		stateDir = filepath.Dir(configPath)
+	}
+	return nil
+}
+
 func main() {
 	flag.BoolVar(&safe, "safe", false, "Ignore bulbs that don't connect on start up. Can also be set by using the SAFE environment variable")
+	flag.BoolVar(&catchUp, "reconcile", true, "Apply each device's most recently scheduled state on start up")
 	flag.StringVar(&configPath, "config", DefaultConfigPath, "Path to config file")
+	flag.StringVar(&stateDir, "state", "", "Directory for persistent state (default: the config file's directory)")
+	flag.DurationVar(&watchInterval, "watch", 10*time.Second, "Interval at which to check the config file for changes, 0 disables")
 	flag.Parse()
 
 	// manually set local timezone for docker container
@@ -144,115 +372,103 @@ func main() {
 		safe = true
 	}
 
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+	case "simulate":
+		os.Exit(simulateCommand(flag.Args()[1:]))
+	case "discover":
+		os.Exit(discover(flag.Args()[1:]))
+	}
+
+	if stateDir == "" {
+		stateDir = filepath.Dir(configPath)
+	}
+
 	cfg, err := config.Open(configPath)
 	if err != nil {
 		log.Fatalf("ERR: read config file failed: %s", err)
 	}
 
+	a, err := loadState(cfg)
+	if err != nil {
+		log.Fatalf("ERR: %s", err)
+	}
+
 	err = cfg.Validate()
 	if err != nil {
 		log.Fatalf("ERR: invalid config: %s", err)
 	}
 
-	devices := make(map[string]device.Device)
-	for label, dev := range cfg.Devices {
-		d, err := device.Connect(label, dev)
-		if err != nil {
-			log.Printf("ERR: connect to device: %s", err)
-			continue
-		}
-		devices[label] = d
-		log.Printf("registered device: %q %s", label, devices[label])
+	status := newConnectivity()
+	devices := connectDevices(cfg, nil, nil, status)
+	if missing := missingDevices(cfg, devices); len(missing) > 0 && !safe {
+		log.Fatalf("ERR: devices not registered: %s", strings.Join(missing, ", "))
 	}
 
-	now := time.Now() // used for logging cron entries
-	lightCron := cron.New()
-	for _, job := range cfg.Jobs {
-		if _, ok := devices[job.Device]; !ok {
-			if safe {
-				log.Printf("ERR: device %q not registered, skipping job", job.Device)
-				continue
-			}
-			os.Exit(1)
-		}
+	transitions, err := newTracker(filepath.Join(stateDir, transitionsFile))
+	if err != nil {
+		log.Fatalf("ERR: load transitions: %s", err)
+	}
 
-		var schedule cron.Schedule
-		var err error
+	a.devices = devices
+	a.transitions = transitions
+	a.connectivity = status
 
-		if strings.HasPrefix(job.Schedule, sunrisePrefix) {
-			s := strings.Split(job.Schedule, " ")
+	lightCron, errs := a.buildCron(cfg, devices, a.seed)
+	for _, err := range errs {
+		log.Printf("ERR: %s", err)
+	}
+	a.cron = lightCron
 
-			var duration time.Duration
-			if len(s) > 1 {
-				duration, err = time.ParseDuration(s[1])
-				if err != nil {
-					log.Printf("ERR: parse sunrise offset: %s", err)
-					continue
-				}
-			}
-			schedule = lamplighter.SunriseSchedule{
-				Location: cfg.Location,
-				Offset:   duration,
-			}
-		} else if strings.HasPrefix(job.Schedule, sunsetPrefix) {
-			s := strings.Split(job.Schedule, " ")
-
-			var duration time.Duration
-			if len(s) > 1 {
-				duration, err = time.ParseDuration(s[1])
-				if err != nil {
-					log.Printf("ERR: parse sunset offset: %s", err)
-					continue
-				}
-			}
-			schedule = lamplighter.SunsetSchedule{
-				Location: cfg.Location,
-				Offset:   duration,
-			}
-		} else {
-			schedule, err = cron.ParseStandard(job.Schedule)
-			if err != nil {
-				log.Printf("ERR: parse schedule: %s", err)
-				continue
-			}
+	for label, dev := range devices {
+		resumed, err := transitions.Resume(label, dev)
+		if err != nil {
+			log.Printf("ERR: %s: resume transition: %s", label, err)
 		}
-
-		// conversion formulas are defined by lifx LAN documentation
-		// https://lan.developer.lifx.com/docs/representing-color-with-hsbk
-		color := &device.Color{
-			Hue:        uint16((job.Hue * 0x10000 / 360.0) % 0x10000),
-			Saturation: uint16(job.Saturation * math.MaxUint16 / 100.0),
-			Brightness: uint16(job.Brightness * math.MaxUint16 / 100.0),
-			Kelvin:     uint16(job.Kelvin),
+		if resumed {
+			log.Printf("resumed transition: %s", label)
+			continue
 		}
 
-		transition, err := time.ParseDuration(job.Transition)
-		if err != nil {
-			log.Printf("ERR: parse job transition: %s", err)
+		if until, ok := a.holds.Until(label, time.Now()); ok {
+			log.Printf("held until %s, skipping reconcile: %s", until.Local().Format(time.RFC3339), label)
 			continue
 		}
 
-		j := Job{
-			Device:     devices[job.Device],
-			Color:      color,
-			Transition: transition,
+		if catchUp {
+			job, fired, err := reconcile(lightCron, label)
+			if err != nil {
+				log.Printf("ERR: %s: reconcile: %s", label, err)
+				continue
+			}
+			if job != nil {
+				log.Printf("reconciled: %s: %s", fired.Local().Format(time.RFC3339), label)
+			}
 		}
-		lightCron.Schedule(schedule, j)
-
-		log.Printf("job: %s: %s", schedule.Next(now).Local().Format(time.RFC3339), j.Device.Label())
 	}
 
-	mux := http.NewServeMux()
-	for label, device := range devices {
-		dev := fmt.Sprintf("/device/%s", label)
-		mux.HandleFunc(dev, device.PowerHandler)
-
-		status := fmt.Sprintf("/device/%s/status", label)
-		mux.HandleFunc(status, device.StatusHandler)
+	lightCron.Start()
+	go a.sweepExpired(sweepInterval)
+	go a.supervise(minReconnectBackoff)
+	go a.reloadOnSignal(configPath)
+	if watchInterval > 0 {
+		go a.watchConfig(configPath, watchInterval)
 	}
 
-	mux.HandleFunc("/devices", deviceHandler(cfg.Devices, devices))
-	mux.HandleFunc("/entries", entryHandler(lightCron))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device/", a.deviceRouter)
+	mux.HandleFunc("/group/", a.groupRouter)
+	mux.HandleFunc("/scene/", a.sceneHandler)
+	mux.HandleFunc("/scenes/", a.scenesRouter)
+	mux.HandleFunc("/jobs", a.jobsRouter)
+	mux.HandleFunc("/jobs/", a.jobsRouter)
+	mux.HandleFunc("/schedule", scheduleHandler(a))
+	mux.HandleFunc("/schedule/", scheduleHandler(a))
+	mux.HandleFunc("/devices", deviceHandler(a))
+	mux.HandleFunc("/entries", entryHandler(a))
+	mux.HandleFunc("/exceptions", exceptionHandler(a))
+	mux.HandleFunc("/config/reload", reloadHandler(a, configPath))
 	mux.HandleFunc("/health", healthHandler)
 
 	srv := http.Server{
@@ -260,7 +476,5 @@ func main() {
 		Handler: mux,
 	}
 	log.Printf("listening on %s", srv.Addr)
-
-	lightCron.Start()
 	log.Fatal(srv.ListenAndServe())
 }
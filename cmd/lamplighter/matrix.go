@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+	"github.com/subtlepseudonym/lamplighter/frame"
+)
+
+// defaultFrameKelvin is used for unsaturated frame pixels when a request
+// doesn't set kelvin
+const defaultFrameKelvin = 3500
+
+// matrixRequest is the body of a request showing a frame or running a
+// firmware effect on a matrix device
+type matrixRequest struct {
+	config.Matrix
+	Frame      [][]string `json:"frame"`
+	Brightness *int       `json:"brightness"` // default 100
+	Kelvin     int        `json:"kelvin"`
+	Transition string     `json:"transition"`
+}
+
+// jobMatrixEffect converts a configured firmware effect into a device
+// effect
+func jobMatrixEffect(matrix *config.Matrix) (*device.MatrixEffect, error) {
+	effectType, err := device.ParseMatrixEffectType(matrix.Effect)
+	if err != nil {
+		return nil, err
+	}
+
+	speed, duration, err := matrix.Parameters()
+	if err != nil {
+		return nil, err
+	}
+
+	effect := &device.MatrixEffect{
+		Type:     effectType,
+		Speed:    speed,
+		Duration: duration,
+	}
+	for i, c := range matrix.Palette {
+		color, _, err := stateColor(c.State())
+		if err != nil {
+			return nil, fmt.Errorf("palette[%d]: %w", i, err)
+		}
+		effect.Palette = append(effect.Palette, *color)
+	}
+
+	return effect, nil
+}
+
+// setFrame transitions the job's device to its frame, dimmed to the
+// job's brightness
+func (j Job) setFrame() error {
+	dev, ok := j.Device.(device.Matrix)
+	if !ok {
+		return fmt.Errorf("%s: %w", j.Device.Label(), device.ErrNotMatrix)
+	}
+
+	// frames replace any single color transition in progress
+	if j.Tracker != nil {
+		j.Tracker.Clear(j.Label)
+	}
+	return dev.SetFrame(j.Frame, j.Color.Brightness, j.Color.Kelvin, j.Transition)
+}
+
+// setMatrixEffect runs the job's firmware effect on its device
+func (j Job) setMatrixEffect() error {
+	dev, ok := j.Device.(device.Matrix)
+	if !ok {
+		return fmt.Errorf("%s: %w", j.Device.Label(), device.ErrNotMatrix)
+	}
+
+	if j.Tracker != nil {
+		j.Tracker.Clear(j.Label)
+	}
+	return dev.MatrixEffect(j.MatrixEffect)
+}
+
+// runMatrixEffect runs the job's firmware effect, logging the outcome
+func (j Job) runMatrixEffect() {
+	log.Printf(
+		`{"device": %q, "matrix_effect": %q, "speed": %q, "duration": %q}`,
+		j.Device.Label(),
+		j.MatrixEffect.Type,
+		j.MatrixEffect.Speed,
+		j.MatrixEffect.Duration,
+	)
+
+	err := j.setMatrixEffect()
+	if err != nil {
+		log.Printf("ERR: run matrix effect: %s", err)
+		return
+	}
+	j.Connectivity.Seen(j.Label, time.Now())
+}
+
+// matrixHandler reports the size and pixel colors of a matrix device, or
+// shows a frame or runs a firmware effect from a JSON body. Frames are
+// given as rows of hex colors and scaled to fit the device.
+func matrixHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, devices, _ := a.snapshot()
+		dev, ok := devices[label].(device.Matrix)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, device.ErrNotMatrix.Error())
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeMatrix(w, dev)
+		case http.MethodPost, http.MethodPut:
+			var req matrixRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("decode matrix: %s", err))
+				return
+			}
+
+			switch {
+			case len(req.Frame) > 0 && req.Effect != "":
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "only one of frame or effect may be set"}`))
+			case len(req.Frame) > 0:
+				handleFrame(w, a, label, dev, req)
+			case req.Effect != "":
+				handleMatrixEffect(w, a, label, dev, req)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "frame or effect is required"}`))
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+		}
+	})
+}
+
+// handleFrame shows the request's frame on the device
+func handleFrame(w http.ResponseWriter, a *app, label string, dev device.Matrix, req matrixRequest) {
+	f, err := frame.FromRows(req.Frame)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("frame: %s", err))
+		return
+	}
+
+	brightness := 100
+	if req.Brightness != nil {
+		brightness = *req.Brightness
+	}
+	if brightness < 0 || brightness > 100 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "brightness must be between 0 and 100"}`))
+		return
+	}
+
+	kelvin := req.Kelvin
+	if kelvin == 0 {
+		kelvin = defaultFrameKelvin
+	}
+	if kelvin < 1500 || kelvin > 9000 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "kelvin must be between 1500 and 9000"}`))
+		return
+	}
+
+	transition := time.Duration(0)
+	if req.Transition != "" {
+		transition, err = time.ParseDuration(req.Transition)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("parse transition: %s", err))
+			return
+		}
+	}
+
+	a.transitions.Clear(label)
+	err = dev.SetFrame(f, uint16(brightness*math.MaxUint16/100), uint16(kelvin), transition)
+	if err != nil {
+		writeFeatureError(w, err, "unable to set device frame")
+		return
+	}
+	a.override(label)
+
+	writeMatrix(w, dev)
+}
+
+// handleMatrixEffect runs the request's firmware effect on the device
+func handleMatrixEffect(w http.ResponseWriter, a *app, label string, dev device.Matrix, req matrixRequest) {
+	if len(req.Palette) > config.MaxMatrixPalette {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": "palette has more than %d colors"}`, config.MaxMatrixPalette)
+		return
+	}
+
+	effect, err := jobMatrixEffect(&req.Matrix)
+	if err == nil && effect.Speed <= 0 {
+		err = fmt.Errorf("speed must be positive")
+	}
+	if err == nil && effect.Duration < 0 {
+		err = fmt.Errorf("duration must not be negative")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": %q}`, err.Error())
+		return
+	}
+
+	a.transitions.Clear(label)
+	err = dev.MatrixEffect(effect)
+	if err != nil {
+		writeFeatureError(w, err, "unable to run effect on device")
+		return
+	}
+	a.override(label)
+
+	fmt.Fprintf(
+		w,
+		`{"effect": %q, "speed": %q, "duration": %q, "palette": %s}`,
+		effect.Type,
+		effect.Speed,
+		effect.Duration,
+		device.ColorsJSON(effect.Palette),
+	)
+}
+
+// writeMatrix responds with the device's size and the color of each of
+// its pixels, row by row from the top
+func writeMatrix(w http.ResponseWriter, dev device.Matrix) {
+	width, height, err := dev.Size()
+	if err != nil {
+		writeFeatureError(w, err, "unable to get device size")
+		return
+	}
+
+	pixels, err := dev.Pixels()
+	if err != nil {
+		writeFeatureError(w, err, "unable to get device pixels")
+		return
+	}
+
+	rows := make([]string, len(pixels))
+	for i, row := range pixels {
+		rows[i] = device.ColorsJSON(row)
+	}
+	fmt.Fprintf(w, `{"width": %d, "height": %d, "pixels": [%s]}`, width, height, strings.Join(rows, ", "))
+}
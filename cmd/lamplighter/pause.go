@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const pausedFile = "paused.json"
+
+// pauseState records jobs which have been paused or resumed through the
+// HTTP API, overriding their configured enabled flag, and whether all
+// scheduling is paused. It's persisted so that it survives a restart.
+type pauseState struct {
+	path string
+
+	mu   sync.Mutex
+	All  bool            `json:"all"`
+	Jobs map[string]bool `json:"jobs"` // job ID to enabled
+}
+
+// newPauseState loads the pause state from the given path, if it exists
+func newPauseState(path string) (*pauseState, error) {
+	p := &pauseState{
+		path: path,
+		Jobs: make(map[string]bool),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read paused jobs: %w", err)
+	}
+
+	err = json.Unmarshal(b, p)
+	if err != nil {
+		return nil, fmt.Errorf("decode paused jobs: %w", err)
+	}
+	if p.Jobs == nil {
+		p.Jobs = make(map[string]bool)
+	}
+
+	return p, nil
+}
+
+// Paused reports whether the job should be skipped, given whether it's
+// enabled in its config
+func (p *pauseState) Paused(id string, enabled bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.All {
+		return true
+	}
+	if override, ok := p.Jobs[id]; ok && id != "" {
+		return !override
+	}
+	return !enabled
+}
+
+// SetJob pauses or resumes the job. Overrides which match the job's
+// configured enabled flag are dropped.
+func (p *pauseState) SetJob(id string, enabled, configured bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if enabled == configured {
+		delete(p.Jobs, id)
+	} else {
+		p.Jobs[id] = enabled
+	}
+	p.save()
+}
+
+// SetAll pauses or resumes all scheduling
+func (p *pauseState) SetAll(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.All = paused
+	p.save()
+}
+
+// AllPaused reports whether all scheduling is paused
+func (p *pauseState) AllPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.All
+}
+
+// save writes the pause state to disk. The caller must hold p.mu.
+func (p *pauseState) save() {
+	b, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("ERR: encode paused jobs: %s", err)
+		return
+	}
+
+	err = os.WriteFile(p.path, b, 0644)
+	if err != nil {
+		log.Printf("ERR: write paused jobs: %s", err)
+	}
+}
+
+// pauseJobHandler pauses or resumes a single job
+func pauseJobHandler(a *app, id string, enabled bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		cfg, _, _ := a.snapshot()
+		for _, job := range cfg.Jobs {
+			if job.ID != id {
+				continue
+			}
+
+			a.paused.SetJob(id, enabled, job.IsEnabled())
+			status := "paused"
+			if enabled {
+				status = "resumed"
+			}
+			log.Printf("%s job: %s", status, id)
+			fmt.Fprintf(w, `{"status": %q}`, status)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "job not found"}`))
+	})
+}
+
+// scheduleHandler reports whether scheduling is paused, and pauses or
+// resumes all scheduled jobs
+func scheduleHandler(a *app) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/schedule"), "/")
+		if action == "" && r.Method == http.MethodGet {
+			fmt.Fprintf(w, `{"paused": %t}`, a.paused.AllPaused())
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		switch action {
+		case "pause":
+			a.paused.SetAll(true)
+			log.Printf("paused all scheduling")
+		case "resume":
+			a.paused.SetAll(false)
+			log.Printf("resumed all scheduling")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "unknown schedule endpoint"}`))
+			return
+		}
+
+		fmt.Fprintf(w, `{"paused": %t}`, a.paused.AllPaused())
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+
+	"github.com/robfig/cron/v3"
+)
+
+const reconcileTransition = 2 * time.Second
+
+// previousJob returns the device's job which most recently should have
+// fired and the time at which it should have done so. If none of the
+// device's jobs have fired, the returned job is nil.
+func previousJob(lightCron *cron.Cron, label string, now time.Time) (*Job, time.Time) {
+	var previous *Job
+	var fired time.Time
+	for _, entry := range lightCron.Entries() {
+		job, ok := entry.Job.(Job)
+		if !ok || job.Label != label || job.Paused() {
+			continue
+		}
+		// transient effects leave the device as they found it, as do
+		// firmware effects with a duration
+		if job.Effect != nil && !job.Effect.Persist {
+			continue
+		}
+		if job.MatrixEffect != nil && job.MatrixEffect.Duration > 0 {
+			continue
+		}
+
+		t := lamplighter.Previous(entry.Schedule, now)
+		if _, run := job.Except(t); !run {
+			continue
+		}
+		if !t.IsZero() && t.After(fired) {
+			previous = &job
+			fired = t
+		}
+	}
+
+	return previous, fired
+}
+
+// reconcile transitions the device to the state set by its most recently
+// fired job, so that it matches its schedule after lamplighter starts. If
+// that job's transition would still be in progress, the device fades to
+// its target over the remaining time. The returned job's transition is
+// the one applied. Circadian devices which are switched off are left off,
+// and no job is returned.
+func reconcile(lightCron *cron.Cron, label string) (*Job, time.Time, error) {
+	now := time.Now()
+	job, fired := previousJob(lightCron, label, now)
+	if job == nil {
+		return nil, fired, nil
+	}
+
+	if job.Circadian != nil {
+		off, err := switchedOff(job.Device)
+		if err != nil {
+			return nil, fired, err
+		}
+		if off {
+			return nil, fired, nil
+		}
+	}
+
+	// circadian devices fade to the point on the curve which the most
+	// recent step was heading for
+	*job, _ = job.Except(fired)
+	*job = job.At(fired)
+
+	remaining := fired.Add(job.Transition).Sub(now)
+	job.Transition = reconcileTransition
+	if remaining > reconcileTransition {
+		job.Transition = remaining
+	}
+
+	var err error
+	if job.MatrixEffect != nil {
+		err = job.setMatrixEffect()
+	} else if job.Frame != nil {
+		err = job.setFrame()
+	} else if job.Zones != nil {
+		err = job.setZones()
+	} else if job.Tracker != nil {
+		err = job.Tracker.Transition(job.Label, job.Device, job.Color, job.Transition)
+	} else {
+		err = job.Device.Transition(job.Color, job.Transition)
+	}
+	if err != nil {
+		return nil, fired, fmt.Errorf("transition device: %w", err)
+	}
+
+	return job, fired, nil
+}
+
+func reconcileHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		_, _, lightCron := a.snapshot()
+		job, fired, err := reconcile(lightCron, label)
+		if err != nil {
+			log.Printf("ERR: %s: reconcile: %s", label, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "unable to reconcile device state"}`))
+			return
+		}
+
+		if job == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "no scheduled job has fired for device"}`))
+			return
+		}
+
+		fmt.Fprintf(
+			w,
+			`{"fired": %q, "hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d, "transition": %q}`,
+			fired.Local().Format(time.RFC3339),
+			float64(job.Color.Hue)*360.0/0x10000,
+			float64(job.Color.Saturation)/math.MaxUint16*100,
+			float64(job.Color.Brightness)/math.MaxUint16*100,
+			job.Color.Kelvin,
+			job.Transition,
+		)
+	})
+}
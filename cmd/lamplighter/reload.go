@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+)
+
+// reload reads and validates the config file and, if it's valid, connects
+// any new or changed devices and replaces the running cron. If the new
+// config is invalid, the running config is left untouched.
+func (a *app) reload(path string) error {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	cfg, err := config.Open(path)
+	if err != nil {
+		return err
+	}
+	a.scenes.Merge(cfg)
+	a.jobs.Merge(cfg)
+
+	return a.apply(cfg)
+}
+
+// apply validates the config and, if it's valid, makes it the running
+// config. The caller must hold a.reloadMu.
+func (a *app) apply(cfg *config.Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	current, connected, oldCron := a.snapshot()
+	devices := connectDevices(cfg, current, connected, a.connectivity)
+	if missing := missingDevices(cfg, devices); len(missing) > 0 && !safe {
+		return fmt.Errorf("devices not registered: %s", strings.Join(missing, ", "))
+	}
+
+	seed := a.seed
+	if cfg.Seed != nil {
+		seed = *cfg.Seed
+	}
+
+	lightCron, errs := a.buildCron(cfg, devices, seed)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid jobs: %w", errors.Join(errs...))
+	}
+
+	for label, dev := range connected {
+		if devices[label] != dev {
+			a.transitions.Clear(label)
+		}
+		if _, ok := devices[label]; !ok {
+			log.Printf("removed device: %q", label)
+		}
+	}
+
+	a.mu.Lock()
+	a.cfg = cfg
+	a.devices = devices
+	a.cron = lightCron
+	a.seed = seed
+	a.mu.Unlock()
+
+	oldCron.Stop()
+	lightCron.Start()
+
+	log.Printf("reloaded config: %d devices, %d entries", len(devices), len(lightCron.Entries()))
+	return nil
+}
+
+// reloadOnSignal reloads the config whenever the process receives SIGHUP
+func (a *app) reloadOnSignal(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Printf("received SIGHUP, reloading config")
+		err := a.reload(path)
+		if err != nil {
+			log.Printf("ERR: reload config: %s", err)
+		}
+	}
+}
+
+// watchConfig polls the config file and reloads it when its modification
+// time changes
+func (a *app) watchConfig(path string, interval time.Duration) {
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modified) {
+			continue
+		}
+		modified = info.ModTime()
+
+		log.Printf("config file changed, reloading config")
+		err = a.reload(path)
+		if err != nil {
+			log.Printf("ERR: reload config: %s", err)
+		}
+	}
+}
+
+func reloadHandler(a *app, path string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+			return
+		}
+
+		err := a.reload(path)
+		if err != nil {
+			log.Printf("ERR: reload config: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, err.Error())
+			return
+		}
+
+		w.Write([]byte(`{"status": "reloaded"}`))
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// sceneHandler applies the scene named by the request path to all of its
+// devices at once
+func (a *app) sceneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/scene/")
+	cfg, devices, _ := a.snapshot()
+	scene, ok := cfg.Scenes[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "scene not found"}`))
+		return
+	}
+
+	results, ok, err := a.applyScene(scene, devices)
+	if err != nil {
+		log.Printf("ERR: apply scene %q: %s", name, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"error": %q}`, err.Error())
+		return
+	}
+	writeResults(w, results, ok)
+}
+
+// applyScene transitions each device in the scene to its state
+// concurrently and returns the per-device results. The scene is only
+// applied if every one of its devices is registered, so that it's never
+// partially applied because of a missing device.
+func (a *app) applyScene(scene config.Scene, devices map[string]device.Device) (map[string]result, bool, error) {
+	var missing []string
+	for _, label := range scene.Devices() {
+		if _, ok := devices[label]; !ok {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, false, fmt.Errorf("devices not registered: %s", strings.Join(missing, ", "))
+	}
+
+	results, ok := fanOut(scene.Devices(), devices, func(label string, dev device.Device) result {
+		color, transition, err := stateColor(scene[label])
+		if err != nil {
+			return result{Error: err.Error()}
+		}
+
+		err = dev.Transition(color, transition)
+		if err != nil {
+			log.Printf("ERR: %s: transition: %s", label, err)
+			return result{Error: fmt.Sprintf("unable to set brightness on device: %s", err)}
+		}
+		a.override(label)
+		return colorResult(color)
+	})
+
+	return results, ok, nil
+}
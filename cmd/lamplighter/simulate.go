@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultSimulationDays = 7
+
+	// limits the number of firings simulated per entry, such as for
+	// circadian entries with short intervals
+	maxSimulatedFirings = 100000
+)
+
+// SunTimes holds the solar events on the day of a firing
+type SunTimes struct {
+	Dawn    string `json:"dawn,omitempty"`
+	Sunrise string `json:"sunrise,omitempty"`
+	Noon    string `json:"noon,omitempty"`
+	Sunset  string `json:"sunset,omitempty"`
+	Dusk    string `json:"dusk,omitempty"`
+}
+
+// DeviceState is the simulated state of a device
+type DeviceState struct {
+	Hue        float64 `json:"hue"`
+	Saturation float64 `json:"saturation"`
+	Brightness float64 `json:"brightness"`
+	Kelvin     uint16  `json:"kelvin"`
+}
+
+// Firing is a single simulated activation of a cron entry
+type Firing struct {
+	Time         string       `json:"time"`
+	Entry        cron.EntryID `json:"entry"`
+	Job          string       `json:"job,omitempty"`
+	Device       string       `json:"device"`
+	Group        string       `json:"group,omitempty"`
+	Scene        string       `json:"scene,omitempty"`
+	Circadian    bool         `json:"circadian,omitempty"`
+	Hue          float64      `json:"hue"`
+	Saturation   float64      `json:"saturation"`
+	Brightness   float64      `json:"brightness"`
+	Kelvin       uint16       `json:"kelvin"`
+	Transition   string       `json:"transition"`
+	Fallback     bool         `json:"fallback,omitempty"`
+	Exception    string       `json:"exception,omitempty"`
+	Effect       string       `json:"effect,omitempty"`
+	Zones        bool         `json:"zones,omitempty"`
+	Frame        bool         `json:"frame,omitempty"`
+	MatrixEffect string       `json:"matrix_effect,omitempty"`
+
+	// Skipped is why the firing doesn't change the device: "paused",
+	// "held", "exception", or "off" for circadian steps on a device
+	// which an earlier firing switched off
+	Skipped string `json:"skipped,omitempty"`
+
+	// State is the device's state after the firing, or nil if no firing
+	// has set the device yet
+	State *DeviceState `json:"state"`
+	Sun   SunTimes     `json:"sun"`
+
+	time      time.Time
+	transient bool // an effect which doesn't leave the device at a color
+}
+
+// simulate lists every firing of the cron's entries from from until to,
+// in order, along with the state each device would be left in. Devices
+// are not contacted.
+func simulate(lightCron *cron.Cron, location lamplighter.Location, h *holds, from, to time.Time) []Firing {
+	sun := make(map[string]SunTimes)
+	sunTimes := func(t time.Time) SunTimes {
+		date := t.Local().Format(lamplighter.DateLayout)
+		if s, ok := sun[date]; ok {
+			return s
+		}
+
+		events := lamplighter.SolarEventsOn(location, t.Local())
+		format := func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Local().Format(time.RFC3339)
+		}
+		s := SunTimes{
+			Dawn:    format(events.Dawn),
+			Sunrise: format(events.Sunrise),
+			Noon:    format(events.Noon),
+			Sunset:  format(events.Sunset),
+			Dusk:    format(events.Dusk),
+		}
+		sun[date] = s
+		return s
+	}
+
+	var firings []Firing
+	for _, entry := range lightCron.Entries() {
+		job, ok := entry.Job.(Job)
+		if !ok {
+			continue
+		}
+
+		previous := from
+		t := entry.Schedule.Next(from)
+		for i := 0; i < maxSimulatedFirings && !t.IsZero() && !t.After(to); i++ {
+			resolved := job.At(t)
+			resolved, run := resolved.Except(t)
+
+			f := Firing{
+				Time:       t.Local().Format(time.RFC3339),
+				Entry:      entry.ID,
+				Job:        job.ID,
+				Device:     job.Label,
+				Group:      resolved.Group,
+				Scene:      resolved.Scene,
+				Circadian:  job.Circadian != nil,
+				Hue:        float64(resolved.Color.Hue) * 360.0 / 0x10000,
+				Saturation: float64(resolved.Color.Saturation) / math.MaxUint16 * 100,
+				Brightness: float64(resolved.Color.Brightness) / math.MaxUint16 * 100,
+				Kelvin:     resolved.Color.Kelvin,
+				Transition: resolved.Transition.String(),
+				Exception:  resolved.Event,
+				Sun:        sunTimes(t),
+				time:       t,
+			}
+			if resolved.Effect != nil {
+				f.Effect = string(resolved.Effect.Waveform)
+				f.transient = !resolved.Effect.Persist
+			}
+			f.Zones = resolved.Zones != nil
+			f.Frame = resolved.Frame != nil
+			if resolved.MatrixEffect != nil {
+				// as in reconcile, effects without a duration are the
+				// device's state until it's set again
+				f.MatrixEffect = string(resolved.MatrixEffect.Type)
+				f.transient = resolved.MatrixEffect.Duration > 0
+			}
+			if schedule, ok := entry.Schedule.(lamplighter.FallbackSchedule); ok {
+				f.Fallback = schedule.IsFallback(previous)
+			}
+
+			switch {
+			case job.Paused():
+				f.Skipped = "paused"
+			case h != nil && h.Held(f.Device, t):
+				f.Skipped = "held"
+			case !run:
+				f.Skipped = "exception"
+			}
+
+			firings = append(firings, f)
+			previous = t
+			t = entry.Schedule.Next(t)
+		}
+	}
+
+	sort.SliceStable(firings, func(i, j int) bool {
+		if firings[i].time.Equal(firings[j].time) {
+			return firings[i].Entry < firings[j].Entry
+		}
+		return firings[i].time.Before(firings[j].time)
+	})
+
+	states := make(map[string]*DeviceState)
+	for i, f := range firings {
+		// as in Job.Run, circadian steps leave switched off devices alone
+		if state := states[f.Device]; f.Skipped == "" && f.Circadian && state != nil && state.Brightness == 0 {
+			f.Skipped = "off"
+			firings[i].Skipped = f.Skipped
+		}
+
+		if f.Skipped == "" && !f.transient {
+			states[f.Device] = &DeviceState{
+				Hue:        f.Hue,
+				Saturation: f.Saturation,
+				Brightness: f.Brightness,
+				Kelvin:     f.Kelvin,
+			}
+		}
+		firings[i].State = states[f.Device]
+	}
+
+	return firings
+}
+
+// parseRange parses the start and end of a simulation. Dates include the
+// whole day; times may also be given in RFC 3339 format. If from is
+// empty, it defaults to now, and if to is empty, it defaults to a week
+// after from.
+func parseRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	parse := func(s string, end bool) (time.Time, error) {
+		t, err := time.ParseInLocation(lamplighter.DateLayout, s, time.Local)
+		if err == nil {
+			if end {
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+
+	start := now
+	if from != "" {
+		var err error
+		start, err = parse(from, false)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("parse from: %w", err)
+		}
+	}
+
+	end := start.AddDate(0, 0, defaultSimulationDays)
+	if to != "" {
+		var err error
+		end, err = parse(to, true)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("parse to: %w", err)
+		}
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New("to must not be before from")
+	}
+	return start, end, nil
+}
+
+// simulationHandler serves the entries endpoint when a range is given,
+// listing every firing in the range rather than each entry's next firing
+func simulationHandler(a *app, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseRange(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": %q}`, err.Error())
+		return
+	}
+
+	cfg, _, lightCron := a.snapshot()
+	firings := simulate(lightCron, cfg.Location, a.holds, from, to)
+
+	err = json.NewEncoder(w).Encode(firings)
+	if err != nil {
+		log.Printf("ERR: write simulation: %s", err)
+	}
+}
+
+// simulatedDevice stands in for a device during a simulation so that
+// jobs can be scheduled without connecting to real devices
+type simulatedDevice struct {
+	label string
+}
+
+func (d simulatedDevice) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+func (d simulatedDevice) PowerHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+func (d simulatedDevice) Transition(*device.Color, time.Duration) error {
+	return fmt.Errorf("%s: simulated device", d.label)
+}
+
+func (d simulatedDevice) Status() (*device.Color, error) {
+	return nil, fmt.Errorf("%s: simulated device", d.label)
+}
+
+func (d simulatedDevice) Label() string {
+	return d.label
+}
+
+func (d simulatedDevice) String() string {
+	return "simulated"
+}
+
+// simulateCommand prints every job firing in a date range without
+// connecting to any devices, and returns the process exit code
+func simulateCommand(args []string) int {
+	flags := subcommandFlags("simulate")
+	from := flags.String("from", "", "First date to simulate, formatted as YYYY-MM-DD (default: now)")
+	to := flags.String("to", "", "Last date to simulate, formatted as YYYY-MM-DD (default: a week after from)")
+	asJSON := flags.Bool("json", false, "Print firings as JSON")
+	err := parseSubcommand(flags, args)
+	if err != nil {
+		return 2
+	}
+	path := configPath
+
+	start, end, err := parseRange(*from, *to, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simulate: %s\n", err)
+		return 2
+	}
+
+	// scheduling logs each job, which would drown out the simulation
+	log.SetOutput(io.Discard)
+
+	cfg, err := config.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	// stores are only read, so simulating never changes persistent state
+	a, err := loadState(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid config: %s\n", path, err)
+		return 1
+	}
+
+	devices := make(map[string]device.Device, len(cfg.Devices))
+	for label := range cfg.Devices {
+		devices[label] = simulatedDevice{label: label}
+	}
+
+	lightCron, errs := a.buildCron(cfg, devices, a.seed)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	}
+	if cfg.Seed == nil && jittered(cfg) {
+		fmt.Fprintf(os.Stderr, "%s: seed is not set, so jittered times are random and won't match the daemon's\n", path)
+	}
+
+	// cron only assigns entry IDs as entries are added, so the simulation
+	// matches the entries of a daemon running the same config
+	firings := simulate(lightCron, cfg.Location, a.holds, start, end)
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(firings)
+		return 0
+	}
+
+	printFirings(os.Stdout, firings)
+	return 0
+}
+
+// jittered reports whether any of the config's times are shifted by a
+// random amount
+func jittered(cfg *config.Config) bool {
+	if cfg.Away.Enabled {
+		return true
+	}
+	for _, job := range cfg.Jobs {
+		if job.Jitter != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// printFirings writes the firings as a table grouped by day
+func printFirings(out io.Writer, firings []Firing) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	clock := func(s string) string {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "-"
+		}
+		return t.Format("15:04")
+	}
+
+	var day string
+	for _, f := range firings {
+		if d := f.time.Local().Format(lamplighter.DateLayout); d != day {
+			day = d
+			fmt.Fprintf(w, "\n%s  dawn %s  sunrise %s  noon %s  sunset %s  dusk %s\n",
+				day, clock(f.Sun.Dawn), clock(f.Sun.Sunrise), clock(f.Sun.Noon), clock(f.Sun.Sunset), clock(f.Sun.Dusk))
+		}
+
+		action := fmt.Sprintf("hue %.0f sat %.0f bri %.0f kelvin %d over %s", f.Hue, f.Saturation, f.Brightness, f.Kelvin, f.Transition)
+		if f.Effect != "" {
+			action = fmt.Sprintf("%s effect to hue %.0f sat %.0f bri %.0f kelvin %d", f.Effect, f.Hue, f.Saturation, f.Brightness, f.Kelvin)
+		}
+		if f.Zones {
+			action = fmt.Sprintf("zones from hue %.0f sat %.0f bri %.0f kelvin %d over %s", f.Hue, f.Saturation, f.Brightness, f.Kelvin, f.Transition)
+		}
+		if f.Frame {
+			action = fmt.Sprintf("frame at bri %.0f kelvin %d over %s", f.Brightness, f.Kelvin, f.Transition)
+		}
+		if f.MatrixEffect != "" {
+			action = fmt.Sprintf("%s matrix effect", f.MatrixEffect)
+		}
+		if f.Skipped != "" {
+			action = "skipped: " + f.Skipped
+		}
+
+		source := f.Job
+		switch {
+		case f.Circadian:
+			source = "circadian"
+		case source == "":
+			source = "away"
+		}
+		if f.Exception != "" {
+			source += fmt.Sprintf(" (%s)", f.Exception)
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t\n", f.time.Local().Format("15:04:05"), f.Device, action, source)
+	}
+}
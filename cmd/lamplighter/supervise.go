@@ -0,0 +1,244 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+const (
+	// devices which fail to connect are retried with exponential backoff
+	// between these durations
+	minReconnectBackoff = 5 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+
+	// probeInterval is how often registered devices are checked
+	probeInterval = time.Minute
+)
+
+// deviceState is the connectivity of a single device
+type deviceState struct {
+	online   bool
+	lastSeen time.Time
+	failures int       // consecutive failed attempts
+	retryAt  time.Time // when to next attempt to connect
+	probedAt time.Time
+}
+
+// connectivity tracks which devices are reachable and when to retry those
+// which aren't. It's safe for concurrent use, and updates to a nil
+// connectivity are ignored.
+type connectivity struct {
+	mu      sync.Mutex
+	devices map[string]*deviceState
+}
+
+func newConnectivity() *connectivity {
+	return &connectivity{
+		devices: make(map[string]*deviceState),
+	}
+}
+
+func (c *connectivity) state(label string) *deviceState {
+	s, ok := c.devices[label]
+	if !ok {
+		s = &deviceState{}
+		c.devices[label] = s
+	}
+	return s
+}
+
+// Seen records that the device responded at time t
+func (c *connectivity) Seen(label string, t time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.state(label)
+	s.online = true
+	s.lastSeen = t
+	s.failures = 0
+	s.retryAt = time.Time{}
+	s.probedAt = t
+}
+
+// Failed records that the device didn't respond at time t and schedules
+// the next attempt to connect to it
+func (c *connectivity) Failed(label string, t time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.state(label)
+	s.online = false
+	s.probedAt = t
+
+	backoff := minReconnectBackoff
+	for i := 0; i < s.failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReconnectBackoff {
+		backoff = maxReconnectBackoff
+	}
+	s.failures++
+	s.retryAt = t.Add(backoff)
+}
+
+// Status reports whether the device was reachable when last checked and
+// when it last responded
+func (c *connectivity) Status(label string) (bool, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.devices[label]
+	if !ok {
+		return false, time.Time{}
+	}
+	return s.online, s.lastSeen
+}
+
+// due reports whether an unregistered device should be retried, or a
+// registered device probed, at time t
+func (c *connectivity) due(label string, registered bool, t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.state(label)
+	if registered {
+		return t.Sub(s.probedAt) >= probeInterval
+	}
+	return !t.Before(s.retryAt)
+}
+
+// supervise retries configured devices which aren't registered, backing
+// off after each failure, and adds them along with their jobs once they
+// connect. Registered devices are probed periodically to keep their
+// connectivity current.
+func (a *app) supervise(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.reconnect(time.Now())
+		a.probe(time.Now())
+	}
+}
+
+// reconnect attempts to connect each unregistered device which is due to
+// be retried, registering any which succeed. Connecting may block for
+// discovery, so it happens without holding a.reloadMu.
+func (a *app) reconnect(now time.Time) {
+	cfg, devices, _ := a.snapshot()
+
+	connected := make(map[string]device.Device)
+	for label, dev := range cfg.Devices {
+		if _, ok := devices[label]; ok || !a.connectivity.due(label, false, now) {
+			continue
+		}
+
+		d, err := device.Connect(label, dev)
+		if err != nil {
+			a.connectivity.Failed(label, now)
+			log.Printf("ERR: reconnect to device: %s", err)
+			continue
+		}
+		a.connectivity.Seen(label, now)
+		connected[label] = d
+	}
+
+	if len(connected) > 0 {
+		a.register(cfg, connected)
+	}
+}
+
+// register adds newly connected devices and rebuilds the cron once so that
+// their jobs are scheduled. Devices whose config has changed since they
+// were connected using cfg are dropped, and retried on a later tick.
+func (a *app) register(cfg *config.Config, connected map[string]device.Device) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	current, registered, oldCron := a.snapshot()
+
+	devices := make(map[string]device.Device, len(registered)+len(connected))
+	for label, dev := range registered {
+		devices[label] = dev
+	}
+
+	var added []string
+	for label, dev := range connected {
+		if _, ok := devices[label]; ok || !reflect.DeepEqual(current.Devices[label], cfg.Devices[label]) {
+			continue
+		}
+		devices[label] = dev
+		added = append(added, label)
+		log.Printf("registered device: %q %s", label, dev)
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	lightCron, errs := a.buildCron(current, devices, a.seed)
+	for _, err := range errs {
+		log.Printf("ERR: %s", err)
+	}
+
+	a.mu.Lock()
+	a.devices = devices
+	a.cron = lightCron
+	a.mu.Unlock()
+
+	oldCron.Stop()
+	lightCron.Start()
+
+	for _, label := range added {
+		if until, ok := a.holds.Until(label, time.Now()); ok {
+			log.Printf("held until %s, skipping reconcile: %s", until.Local().Format(time.RFC3339), label)
+			continue
+		}
+		if catchUp {
+			job, fired, err := reconcile(lightCron, label)
+			if err != nil {
+				log.Printf("ERR: %s: reconcile: %s", label, err)
+			} else if job != nil {
+				log.Printf("reconciled: %s: %s", fired.Local().Format(time.RFC3339), label)
+			}
+		}
+	}
+}
+
+// probe checks whether each registered device which is due still responds
+func (a *app) probe(now time.Time) {
+	_, devices, _ := a.snapshot()
+
+	var wg sync.WaitGroup
+	for label, dev := range devices {
+		if !a.connectivity.due(label, true, now) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(label string, dev device.Device) {
+			defer wg.Done()
+
+			_, err := dev.Status()
+			if err != nil {
+				if online, _ := a.connectivity.Status(label); online {
+					log.Printf("ERR: device offline: %s", err)
+				}
+				a.connectivity.Failed(label, time.Now())
+				return
+			}
+			a.connectivity.Seen(label, time.Now())
+		}(label, dev)
+	}
+	wg.Wait()
+}
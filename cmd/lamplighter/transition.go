@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+const (
+	transitionsFile = "transitions.json"
+
+	// transitions shorter than this aren't worth resuming
+	minTrackedTransition = time.Minute
+
+	transitionCheckInterval = 30 * time.Second
+
+	// observed brightness and kelvin may differ from the interpolated
+	// values by this much before a transition is considered interrupted
+	brightnessTolerance = math.MaxUint16 / 10
+	kelvinTolerance     = 500
+)
+
+// fade describes a transition which has been sent to a device but may
+// not have completed
+type fade struct {
+	Device   string       `json:"device"` // config label
+	Start    device.Color `json:"start"`
+	Target   device.Color `json:"target"`
+	Started  time.Time    `json:"started"`
+	Deadline time.Time    `json:"deadline"`
+}
+
+// at returns the interpolated color of the fade at time t
+func (f fade) at(t time.Time) *device.Color {
+	progress := 1.0
+	if total := f.Deadline.Sub(f.Started); total > 0 && t.Before(f.Deadline) {
+		progress = math.Max(0, float64(t.Sub(f.Started))/float64(total))
+	}
+
+	lerp := func(a, b uint16) uint16 {
+		return uint16(math.Round(float64(a) + (float64(b)-float64(a))*progress))
+	}
+
+	// hue is circular, so interpolate in the shorter direction
+	hueDelta := int(f.Target.Hue) - int(f.Start.Hue)
+	if hueDelta > math.MaxUint16/2 {
+		hueDelta -= 0x10000
+	} else if hueDelta < -math.MaxUint16/2 {
+		hueDelta += 0x10000
+	}
+
+	return &device.Color{
+		Hue:        uint16((int(f.Start.Hue) + int(math.Round(float64(hueDelta)*progress)) + 0x10000) % 0x10000),
+		Saturation: lerp(f.Start.Saturation, f.Target.Saturation),
+		Brightness: lerp(f.Start.Brightness, f.Target.Brightness),
+		Kelvin:     lerp(f.Start.Kelvin, f.Target.Kelvin),
+	}
+}
+
+// deviates reports whether the observed color is too far from the
+// expected color for the fade to still be running as sent
+func deviates(observed, expected *device.Color) bool {
+	brightness := math.Abs(float64(observed.Brightness) - float64(expected.Brightness))
+	if brightness > brightnessTolerance {
+		return true
+	}
+
+	// kelvin is meaningless while the device is off
+	kelvin := math.Abs(float64(observed.Kelvin) - float64(expected.Kelvin))
+	return observed.Brightness > 0 && kelvin > kelvinTolerance
+}
+
+// tracker records long transitions on fading devices and persists them
+// so that they can be resumed if lamplighter or the device restarts
+// partway through. Relays such as the S31 and Shelly switch immediately,
+// so they have no transitions to track. Fades are keyed by config label,
+// which a bulb may report differently.
+type tracker struct {
+	path string
+
+	mu     sync.Mutex
+	fades  map[string]fade
+	cancel map[string]context.CancelFunc
+	paused map[string]time.Time // checks are skipped until then
+}
+
+// newTracker loads in-flight transitions from the given path, if it exists
+func newTracker(path string) (*tracker, error) {
+	t := &tracker{
+		path:   path,
+		fades:  make(map[string]fade),
+		cancel: make(map[string]context.CancelFunc),
+		paused: make(map[string]time.Time),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read transitions: %w", err)
+	}
+
+	err = json.Unmarshal(b, &t.fades)
+	if err != nil {
+		return nil, fmt.Errorf("decode transitions: %w", err)
+	}
+
+	return t, nil
+}
+
+// Transition sends the transition to the device with the given config
+// label. If the device fades and the transition is long enough, it's
+// recorded and watched until it completes.
+//
+// Fades to off aren't tracked: bulbs fade their power rather than their
+// color, so the observed color can't show how far the fade has come.
+func (t *tracker) Transition(label string, dev device.Device, color *device.Color, transition time.Duration) error {
+	t.Clear(label)
+
+	fader, ok := dev.(device.Fader)
+	if !ok || !fader.Fades() || transition < minTrackedTransition || color.Brightness == 0 {
+		return dev.Transition(color, transition)
+	}
+
+	start, err := dev.Status()
+	if err != nil {
+		log.Printf("ERR: %s: get status, transition will not be resumable: %s", label, err)
+		return dev.Transition(color, transition)
+	}
+
+	now := time.Now()
+	f := fade{
+		Device:   label,
+		Start:    *start,
+		Target:   *color,
+		Started:  now,
+		Deadline: now.Add(transition),
+	}
+
+	err = dev.Transition(color, transition)
+	if err != nil {
+		return err
+	}
+
+	t.watch(dev, f)
+	return nil
+}
+
+// Resume continues the interrupted transition of the device with the
+// given config label, if it has one, from its interpolated color. It
+// returns false if there is no transition to resume.
+func (t *tracker) Resume(label string, dev device.Device) (bool, error) {
+	t.mu.Lock()
+	f, ok := t.fades[label]
+	t.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if !time.Now().Before(f.Deadline) {
+		t.Clear(label)
+		return false, nil
+	}
+
+	err := resume(dev, f)
+	if err != nil {
+		return true, err
+	}
+
+	t.watch(dev, f)
+	return true, nil
+}
+
+// Pause stops checking the device's transition until the given time, such
+// as while a transient effect runs. If the device hasn't followed the fade
+// by then, the fade is resumed.
+func (t *tracker) Pause(label string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.fades[label]; ok {
+		t.paused[label] = until
+	}
+}
+
+// isPaused reports whether checks of the device's transition are paused
+// at time now
+func (t *tracker) isPaused(label string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.paused[label]
+	if ok && !now.Before(until) {
+		delete(t.paused, label)
+		return false
+	}
+	return ok
+}
+
+// Clear stops tracking the device's transition, such as when it's
+// replaced by a new state
+func (t *tracker) Clear(label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if cancel, ok := t.cancel[label]; ok {
+		cancel()
+		delete(t.cancel, label)
+	}
+	delete(t.paused, label)
+
+	if _, ok := t.fades[label]; ok {
+		delete(t.fades, label)
+		t.save()
+	}
+}
+
+// watch records the fade and periodically checks that the device is
+// following it. The fade is resumed if the device looks like it has
+// reset: it stopped responding or was paused since the last check, or it
+// jumped to the fade's start or target. Any other change was made
+// elsewhere, such as in the LIFX app, and ends tracking.
+func (t *tracker) watch(dev device.Device, f fade) {
+	ctx, cancel := context.WithDeadline(context.Background(), f.Deadline)
+
+	t.mu.Lock()
+	if c, ok := t.cancel[f.Device]; ok {
+		c()
+	}
+	t.fades[f.Device] = f
+	t.cancel[f.Device] = cancel
+	t.save()
+	t.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(transitionCheckInterval)
+		defer ticker.Stop()
+
+		var interrupted bool
+		for {
+			select {
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					t.complete(f)
+				}
+				return
+			case now := <-ticker.C:
+				if t.isPaused(f.Device, now) {
+					interrupted = true
+					continue
+				}
+
+				observed, err := dev.Status()
+				if err != nil {
+					// the device may be restarting, so check again later
+					interrupted = true
+					continue
+				}
+
+				if !deviates(observed, f.at(now)) {
+					interrupted = false
+					continue
+				}
+
+				if !interrupted && deviates(observed, &f.Start) && deviates(observed, &f.Target) {
+					log.Printf("transition changed elsewhere, no longer tracking: %s", f.Device)
+					t.complete(f)
+					return
+				}
+
+				log.Printf("resuming interrupted transition: %s", f.Device)
+				err = resume(dev, f)
+				if err != nil {
+					log.Printf("ERR: %s: resume transition: %s", f.Device, err)
+					continue
+				}
+				interrupted = false
+			}
+		}
+	}()
+}
+
+// complete removes the fade if it's still the device's current fade
+func (t *tracker) complete(f fade) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.fades[f.Device]; ok && current.Started.Equal(f.Started) {
+		if cancel, ok := t.cancel[f.Device]; ok {
+			cancel()
+		}
+		delete(t.fades, f.Device)
+		delete(t.cancel, f.Device)
+		delete(t.paused, f.Device)
+		t.save()
+	}
+}
+
+// save writes in-flight transitions to disk. The caller must hold t.mu.
+func (t *tracker) save() {
+	b, err := json.Marshal(t.fades)
+	if err != nil {
+		log.Printf("ERR: encode transitions: %s", err)
+		return
+	}
+
+	err = os.WriteFile(t.path, b, 0644)
+	if err != nil {
+		log.Printf("ERR: write transitions: %s", err)
+	}
+}
+
+// resume sets the device to the fade's current interpolated color and
+// continues to the target over the remaining time
+func resume(dev device.Device, f fade) error {
+	now := time.Now()
+	err := dev.Transition(f.at(now), 0)
+	if err != nil {
+		return fmt.Errorf("set interpolated color: %w", err)
+	}
+
+	err = dev.Transition(&f.Target, f.Deadline.Sub(now))
+	if err != nil {
+		return fmt.Errorf("continue transition: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+)
+
+// validate checks the config file, printing each problem found, and
+// returns the process exit code
+func validate(args []string) int {
+	flags := subcommandFlags("validate")
+	err := parseSubcommand(flags, args)
+	if err != nil {
+		return 2
+	}
+
+	path := configPath
+	cfg, err := config.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	_, err = loadState(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		var validationErr config.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, fieldErr := range validationErr {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, fieldErr)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s: ok\n", path)
+	return 0
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+	"github.com/subtlepseudonym/lamplighter/device"
+)
+
+// zonesRequest is the body of a request setting a device's zones
+type zonesRequest struct {
+	config.Zones
+	Transition string `json:"transition"`
+}
+
+// zonePattern converts configured zones into a device zone pattern. Zones
+// not covered by the gradient or ranges are set to base, or left as they
+// are if base is nil.
+func zonePattern(zones *config.Zones, base *device.Color) (*device.ZonePattern, error) {
+	pattern := &device.ZonePattern{Base: base}
+	for i, c := range zones.Gradient {
+		color, _, err := stateColor(c.State())
+		if err != nil {
+			return nil, fmt.Errorf("gradient[%d]: %w", i, err)
+		}
+		pattern.Gradient = append(pattern.Gradient, *color)
+	}
+
+	for i, r := range zones.Ranges {
+		if r.Start < 0 || r.End < r.Start {
+			return nil, fmt.Errorf("ranges[%d]: invalid range %d-%d", i, r.Start, r.End)
+		}
+		color, _, err := stateColor(r.State())
+		if err != nil {
+			return nil, fmt.Errorf("ranges[%d]: %w", i, err)
+		}
+		pattern.Ranges = append(pattern.Ranges, device.ZoneRange{
+			Start: r.Start,
+			End:   r.End,
+			Color: *color,
+		})
+	}
+
+	return pattern, nil
+}
+
+// setZones transitions the job's device to its zone pattern
+func (j Job) setZones() error {
+	dev, ok := j.Device.(device.Zoner)
+	if !ok {
+		return fmt.Errorf("%s: %w", j.Device.Label(), device.ErrNotMultizone)
+	}
+
+	// zone patterns replace any single color transition in progress
+	if j.Tracker != nil {
+		j.Tracker.Clear(j.Label)
+	}
+	return dev.SetZones(j.Zones, j.Transition)
+}
+
+// zonesHandler reports the color of each of a multizone device's zones,
+// or sets them from a JSON body of a gradient, ranges, and transition.
+// Zones not covered by the request keep their current color.
+func zonesHandler(a *app, label string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, devices, _ := a.snapshot()
+		dev, ok := devices[label].(device.Zoner)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, device.ErrNotMultizone.Error())
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			zones, err := dev.Zones()
+			if err != nil {
+				writeFeatureError(w, err, "unable to get device zone state")
+				return
+			}
+			writeZones(w, zones)
+		case http.MethodPost, http.MethodPut:
+			var req zonesRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("decode zones: %s", err))
+				return
+			}
+			if len(req.Gradient) == 0 && len(req.Ranges) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "gradient or ranges is required"}`))
+				return
+			}
+
+			pattern, err := zonePattern(&req.Zones, nil)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": %q}`, err.Error())
+				return
+			}
+
+			transition := time.Duration(0)
+			if req.Transition != "" {
+				transition, err = time.ParseDuration(req.Transition)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprintf(w, `{"error": %q}`, fmt.Sprintf("parse transition: %s", err))
+					return
+				}
+			}
+
+			a.transitions.Clear(label)
+			err = dev.SetZones(pattern, transition)
+			if err != nil {
+				writeFeatureError(w, err, "unable to set device zones")
+				return
+			}
+			a.override(label)
+
+			zones, err := dev.Zones()
+			if err != nil {
+				writeFeatureError(w, err, "unable to get device zone state")
+				return
+			}
+			writeZones(w, zones)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(`{"error": "method not allowed"}`))
+		}
+	})
+}
+
+// writeFeatureError responds with a client error for devices without
+// the feature, such as zones, and a server error otherwise
+func writeFeatureError(w http.ResponseWriter, err error, msg string) {
+	for _, featureErr := range []error{device.ErrNotMultizone, device.ErrExtendedMultizone, device.ErrNotMatrix} {
+		if errors.Is(err, featureErr) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": %q}`, featureErr.Error())
+			return
+		}
+	}
+
+	log.Printf("ERR: %s", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `{"error": %q}`, msg)
+}
+
+func writeZones(w http.ResponseWriter, zones []device.Color) {
+	fmt.Fprintf(w, `{"zones": %s}`, device.ColorsJSON(zones))
+}
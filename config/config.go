@@ -3,9 +3,23 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"sort"
+	"time"
 
 	"github.com/subtlepseudonym/lamplighter"
+	"github.com/subtlepseudonym/lamplighter/calendar"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Device types which lamplighter can connect to. The device package's
+// types are defined from these.
+const (
+	DeviceTypeLifx   = "lifx"
+	DeviceTypeS31    = "s31"
+	DeviceTypeShelly = "shelly"
 )
 
 type Device struct {
@@ -19,6 +33,137 @@ type Config struct {
 	Devices  map[string]Device    `json:"devices"`
 	Jobs     []Job                `json:"jobs"`
 	Location lamplighter.Location `json:"location"`
+	Away     Away                 `json:"away"`
+
+	// Groups maps a group name, such as a room, to the labels of its
+	// devices
+	Groups map[string][]string `json:"groups,omitempty"`
+
+	// Scenes are named sets of per-device states which can be applied
+	// together
+	Scenes map[string]Scene `json:"scenes,omitempty"`
+
+	// Circadian lists devices whose color temperature and brightness
+	// follow the sun throughout the day
+	Circadian []Circadian `json:"circadian,omitempty"`
+
+	// Calendars maps a calendar name to the path of an iCalendar file
+	// whose events can be used as job exceptions
+	Calendars map[string]string `json:"calendars,omitempty"`
+
+	// Seed makes randomized activation times reproducible. If it is
+	// unset, a new seed is chosen each time lamplighter starts.
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+// Away configures a vacation mode intended to make the house look
+// lived-in. When enabled, jobs without their own jitter are shifted by
+// Jitter and each listed device is switched on and off Events times at
+// random between Start and End each day.
+type Away struct {
+	Enabled bool     `json:"enabled"`
+	Jitter  string   `json:"jitter"`
+	Devices []string `json:"devices"`
+	Events  int      `json:"events"`
+	Start   string   `json:"start"` // HH:MM
+	End     string   `json:"end"`   // HH:MM
+
+	Brightness int    `json:"brightness"` // 0-100
+	Kelvin     int    `json:"kelvin"`     // 1500-9000
+	Transition string `json:"transition"`
+}
+
+// Circadian makes a device or group follow a curve driven by the sun's
+// elevation. The curve is warmest and dimmest while the sun is at or
+// below LowElevation and coolest and brightest at or above
+// HighElevation. Devices are moved along the curve every Interval, and
+// setting a device by hand pauses its curve for Pause.
+type Circadian struct {
+	Device string `json:"device,omitempty"`
+	Group  string `json:"group,omitempty"`
+
+	MinKelvin     int `json:"min_kelvin"`     // 1500-9000
+	MaxKelvin     int `json:"max_kelvin"`     // 1500-9000
+	MinBrightness int `json:"min_brightness"` // 0-100
+	MaxBrightness int `json:"max_brightness"` // 0-100
+
+	// LowElevation and HighElevation are in degrees above the horizon.
+	// If both are zero, civil twilight (-6) and 30 degrees are used.
+	LowElevation  float64 `json:"low_elevation"`
+	HighElevation float64 `json:"high_elevation"`
+
+	Interval string `json:"interval,omitempty"` // default 5m
+	Pause    string `json:"pause,omitempty"`    // default 1h
+}
+
+const (
+	DefaultCircadianInterval = 5 * time.Minute
+	DefaultCircadianPause    = time.Hour
+
+	defaultLowElevation  = float64(lamplighter.CivilTwilight)
+	defaultHighElevation = 30
+)
+
+// Curve returns the circadian curve at the given location
+func (c Circadian) Curve(location lamplighter.Location) lamplighter.CircadianCurve {
+	low, high := c.LowElevation, c.HighElevation
+	if low == 0 && high == 0 {
+		low, high = defaultLowElevation, defaultHighElevation
+	}
+
+	return lamplighter.CircadianCurve{
+		Location:      location,
+		Low:           low,
+		High:          high,
+		MinKelvin:     uint16(c.MinKelvin),
+		MaxKelvin:     uint16(c.MaxKelvin),
+		MinBrightness: float64(c.MinBrightness),
+		MaxBrightness: float64(c.MaxBrightness),
+	}
+}
+
+// Durations returns the interval between steps along the curve and how
+// long the curve is paused after a manual change, applying defaults
+func (c Circadian) Durations() (interval, pause time.Duration, err error) {
+	interval, pause = DefaultCircadianInterval, DefaultCircadianPause
+	if c.Interval != "" {
+		interval, err = time.ParseDuration(c.Interval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse interval: %w", err)
+		}
+	}
+	if c.Pause != "" {
+		pause, err = time.ParseDuration(c.Pause)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse pause: %w", err)
+		}
+	}
+	return interval, pause, nil
+}
+
+// Scene maps device labels to the state each device should be set to
+// when the scene is applied
+type Scene map[string]State
+
+// State is the desired final state of a single device and how long to
+// take getting there
+type State struct {
+	Hue        int `json:"hue"`        // 0-360
+	Saturation int `json:"saturation"` // 0-100
+	Brightness int `json:"brightness"` // 0-100
+	Kelvin     int `json:"kelvin"`     // 1500-9000
+
+	Transition string `json:"transition"`
+}
+
+// Devices returns the labels of the devices in the scene in sorted order
+func (s Scene) Devices() []string {
+	labels := make([]string, 0, len(s))
+	for label := range s {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
 }
 
 // Job defines when to run, on which device, what the desired final
@@ -27,9 +172,27 @@ type Config struct {
 // Color state is defined using Hue, Saturation, and Brightness. This
 // is referred to as HSB (or HSL) color.
 // https://en.wikipedia.org/wiki/HSL_and_HSV
+//
+// A job targets either a single Device or every device in a Group. A job
+// may instead apply a Scene, in which case its color and transition are
+// ignored in favor of the scene's per-device states.
 type Job struct {
+	// ID identifies the job in the HTTP API. Jobs in the config file
+	// without an ID are given one derived from their target and schedule.
+	ID string `json:"id,omitempty"`
+
+	// API is set on jobs created through the HTTP API rather than
+	// defined in the config file
+	API bool `json:"-"`
+
+	// Enabled may be set to false to keep a job in the config without
+	// running it. Jobs are enabled by default.
+	Enabled *bool `json:"enabled,omitempty"`
+
 	Schedule string `json:"schedule"`
-	Device   string `json:"device"`
+	Device   string `json:"device,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Scene    string `json:"scene,omitempty"`
 
 	Hue        int `json:"hue"`        // 0-360
 	Saturation int `json:"saturation"` // 0-100
@@ -37,6 +200,273 @@ type Job struct {
 	Kelvin     int `json:"kelvin"`     // 1500-9000
 
 	Transition string `json:"transition"`
+
+	// Fallback is a time of day, formatted as HH:MM, at which solar
+	// schedules fire on days when their event does not occur
+	Fallback string `json:"fallback,omitempty"`
+
+	// NotBefore and NotAfter are times of day, formatted as HH:MM, which
+	// clamp the activation times of solar schedules
+	NotBefore string `json:"not_before,omitempty"`
+	NotAfter  string `json:"not_after,omitempty"`
+
+	// Jitter shifts each activation time by a random amount up to this
+	// duration in either direction
+	Jitter string `json:"jitter,omitempty"`
+
+	// StartDate and EndDate, formatted as YYYY-MM-DD, limit the job to
+	// the days between them, inclusive
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+
+	// Exceptions skip or replace the job on days with matching calendar
+	// events. The first matching exception is used.
+	Exceptions []Exception `json:"exceptions,omitempty"`
+
+	// Effect runs a waveform effect toward the job's color instead of
+	// transitioning to it. Effects are only supported by lifx bulbs.
+	Effect *Effect `json:"effect,omitempty"`
+
+	// Zones sets individual zones of multizone lifx bulbs, such as LIFX Z
+	// strips. Zones not covered by the gradient or ranges are set to the
+	// job's color.
+	Zones *Zones `json:"zones,omitempty"`
+
+	// Matrix shows a frame or runs a firmware effect on lifx matrix
+	// devices, such as Tiles and Candles
+	Matrix *Matrix `json:"matrix,omitempty"`
+}
+
+// Zones describes per-zone colors. Gradient stops are spread evenly along
+// the device, then each range is applied over them.
+type Zones struct {
+	Gradient []Color     `json:"gradient,omitempty"`
+	Ranges   []ZoneRange `json:"ranges,omitempty"`
+}
+
+// ZoneRange sets the zones from Start to End, inclusive and counted from
+// zero, to a single color
+type ZoneRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Color
+}
+
+// Color is a single device color without a transition
+type Color struct {
+	Hue        int `json:"hue"`        // 0-360
+	Saturation int `json:"saturation"` // 0-100
+	Brightness int `json:"brightness"` // 0-100
+	Kelvin     int `json:"kelvin"`     // 1500-9000
+}
+
+// State returns the color as a state with no transition
+func (c Color) State() State {
+	return State{
+		Hue:        c.Hue,
+		Saturation: c.Saturation,
+		Brightness: c.Brightness,
+		Kelvin:     c.Kelvin,
+	}
+}
+
+// Effect cycles a device between its current color and the job's color.
+// Waveform is one of saw, sine, half-sine, triangle, or pulse. Unless
+// Persist is set, the device returns to its original color afterward.
+type Effect struct {
+	Waveform string   `json:"waveform"`
+	Period   string   `json:"period,omitempty"` // default 1s
+	Cycles   float64  `json:"cycles,omitempty"` // default 1
+	Skew     *float64 `json:"skew,omitempty"`   // 0-1, default 0.5, pulse only
+	Persist  bool     `json:"persist,omitempty"`
+}
+
+const (
+	DefaultEffectPeriod = time.Second
+	DefaultEffectCycles = 1
+	DefaultEffectSkew   = 0.5
+)
+
+// Parameters returns the effect's period, cycles, and skew, applying
+// defaults
+func (e Effect) Parameters() (period time.Duration, cycles, skew float64, err error) {
+	period, cycles, skew = DefaultEffectPeriod, DefaultEffectCycles, DefaultEffectSkew
+	if e.Period != "" {
+		period, err = time.ParseDuration(e.Period)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("parse period: %w", err)
+		}
+	}
+	if e.Cycles != 0 {
+		cycles = e.Cycles
+	}
+	if e.Skew != nil {
+		skew = *e.Skew
+	}
+	return period, cycles, skew, nil
+}
+
+// Matrix shows Frame, a PNG or JSON frame file scaled to fit the device,
+// or runs one of the device's firmware effects: flame, morph, or off.
+// Frame pixels are dimmed by the job's brightness and unsaturated pixels
+// use its kelvin.
+type Matrix struct {
+	Frame    string  `json:"frame,omitempty"`
+	Effect   string  `json:"effect,omitempty"`
+	Speed    string  `json:"speed,omitempty"`    // default 3s
+	Duration string  `json:"duration,omitempty"` // default forever
+	Palette  []Color `json:"palette,omitempty"`  // morph only
+}
+
+const (
+	DefaultMatrixSpeed = 3 * time.Second
+	MaxMatrixPalette   = 16
+)
+
+// Parameters returns the effect's speed and duration, applying defaults.
+// A duration of zero runs the effect until it's replaced.
+func (m Matrix) Parameters() (speed, duration time.Duration, err error) {
+	speed = DefaultMatrixSpeed
+	if m.Speed != "" {
+		speed, err = time.ParseDuration(m.Speed)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse speed: %w", err)
+		}
+	}
+	if m.Duration != "" {
+		duration, err = time.ParseDuration(m.Duration)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse duration: %w", err)
+		}
+	}
+	return speed, duration, nil
+}
+
+// Exception skips a job on days when the calendar has an event matching
+// Match, or applies Scene instead if it is set. Match is compared against
+// each event's summary and categories, ignoring case; if it is empty,
+// every event matches.
+type Exception struct {
+	Calendar string `json:"calendar"`
+	Match    string `json:"match,omitempty"`
+	Scene    string `json:"scene,omitempty"`
+}
+
+// OpenCalendars reads each of the config's calendar files
+func (c *Config) OpenCalendars() (map[string]*calendar.Calendar, error) {
+	calendars := make(map[string]*calendar.Calendar, len(c.Calendars))
+	for name, path := range c.Calendars {
+		cal, err := calendar.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("calendars.%s: %w", name, err)
+		}
+		calendars[name] = cal
+	}
+	return calendars, nil
+}
+
+// IsEnabled reports whether the job is enabled in the config
+func (j Job) IsEnabled() bool {
+	return j.Enabled == nil || *j.Enabled
+}
+
+// Targets returns the labels of the devices targeted by the job
+func (c *Config) Targets(job Job) []string {
+	switch {
+	case job.Scene != "":
+		return c.Scenes[job.Scene].Devices()
+	case job.Group != "":
+		return c.Groups[job.Group]
+	default:
+		return []string{job.Device}
+	}
+}
+
+// CircadianTargets returns the labels of the devices following the curve
+func (c *Config) CircadianTargets(circadian Circadian) []string {
+	if circadian.Group != "" {
+		return c.Groups[circadian.Group]
+	}
+	return []string{circadian.Device}
+}
+
+// State returns the state the job sets on the labelled device
+func (c *Config) State(job Job, label string) State {
+	if job.Scene != "" {
+		return c.Scenes[job.Scene][label]
+	}
+
+	return State{
+		Hue:        job.Hue,
+		Saturation: job.Saturation,
+		Brightness: job.Brightness,
+		Kelvin:     job.Kelvin,
+		Transition: job.Transition,
+	}
+}
+
+// ParseSchedule parses the job's schedule, along with its fallback and
+// bounds, at the given location
+func (j Job) ParseSchedule(location lamplighter.Location) (cron.Schedule, error) {
+	var err error
+	parser := lamplighter.Parser{Location: location}
+	parser.Fallback, err = optionalClock(j.Fallback)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule fallback: %w", err)
+	}
+	parser.NotBefore, err = optionalClock(j.NotBefore)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule not_before: %w", err)
+	}
+	parser.NotAfter, err = optionalClock(j.NotAfter)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule not_after: %w", err)
+	}
+
+	schedule, err := parser.Parse(j.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := optionalDate(j.StartDate)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule start_date: %w", err)
+	}
+	end, err := optionalDate(j.EndDate)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule end_date: %w", err)
+	}
+	if start.IsZero() && end.IsZero() {
+		return schedule, nil
+	}
+
+	return lamplighter.DatedSchedule{
+		Schedule: schedule,
+		Start:    start,
+		End:      end,
+	}, nil
+}
+
+// optionalDate parses a date in the local time zone, returning the zero
+// time if s is empty
+func optionalDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(lamplighter.DateLayout, s, time.Local)
+}
+
+// optionalClock parses a time of day, returning nil if s is empty
+func optionalClock(s string) (*lamplighter.Clock, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	clock, err := lamplighter.ParseClock(s)
+	if err != nil {
+		return nil, err
+	}
+	return &clock, nil
 }
 
 func Open(filename string) (*Config, error) {
@@ -44,22 +474,56 @@ func Open(filename string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read config file: %w", err)
 	}
+	defer f.Close()
 
 	var config Config
 	err = json.NewDecoder(f).Decode(&config)
 	if err != nil {
+		if line, col, ok := position(f, err); ok {
+			return nil, fmt.Errorf("decode config file: line %d, column %d: %w", line, col, err)
+		}
 		return nil, fmt.Errorf("decode config file: %w", err)
 	}
+	config.assignIDs()
 
 	return &config, nil
 }
 
-func (c *Config) Validate() error {
+// assignIDs gives each job without an ID one derived from its target and
+// a hash of its schedule, so that it keeps its ID, along with any state
+// such as being paused, while its color and other fields are edited. Jobs
+// with the same target and schedule are distinguished by a numeric
+// suffix.
+func (c *Config) assignIDs() {
+	used := make(map[string]bool)
 	for _, job := range c.Jobs {
-		if _, ok := c.Devices[job.Device]; !ok {
-			return fmt.Errorf("schedule references missing device %q", job.Device)
+		if job.ID != "" {
+			used[job.ID] = true
 		}
 	}
 
-	return nil
+	for i, job := range c.Jobs {
+		if job.ID != "" {
+			continue
+		}
+
+		target := job.Device
+		if job.Group != "" {
+			target = job.Group
+		} else if job.Scene != "" {
+			target = job.Scene
+		}
+
+		hash := fnv.New32a()
+		hash.Write([]byte(job.Schedule))
+		base := fmt.Sprintf("%s-%08x", target, hash.Sum32())
+
+		id := base
+		for n := 2; used[id]; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		used[id] = true
+		c.Jobs[i].ID = id
+	}
 }
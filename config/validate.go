@@ -0,0 +1,548 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/calendar"
+	"github.com/subtlepseudonym/lamplighter/frame"
+)
+
+// DeviceTypes lists the device types which lamplighter can connect to
+var DeviceTypes = []string{DeviceTypeLifx, DeviceTypeS31, DeviceTypeShelly}
+
+// FieldError describes an invalid value and its JSON path within the
+// config, such as "jobs[3].transition"
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError collects every problem found while validating a config
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validator accumulates field errors
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+func (v *validator) check(path string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, FieldError{Path: path, Err: err})
+	}
+}
+
+func (v *validator) inRange(path string, value, min, max int) {
+	if value < min || value > max {
+		v.add(path, "%d out of range [%d, %d]", value, min, max)
+	}
+}
+
+func (v *validator) duration(path, value string) {
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.check(path, err)
+	} else if d < 0 {
+		v.add(path, "must not be negative")
+	}
+}
+
+func (v *validator) clock(path, value string) {
+	_, err := optionalClock(value)
+	v.check(path, err)
+}
+
+func (v *validator) kelvin(path string, value int) {
+	// zero leaves kelvin unset
+	if value != 0 {
+		v.inRange(path, value, 1500, 9000)
+	}
+}
+
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate checks every field of the config and returns a
+// ValidationError listing all problems found, or nil if there are none
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if c.Location.Latitude < -90 || c.Location.Latitude > 90 {
+		v.add("location.latitude", "%g out of range [-90, 90]", c.Location.Latitude)
+	}
+	if c.Location.Longitude < -180 || c.Location.Longitude > 180 {
+		v.add("location.longitude", "%g out of range [-180, 180]", c.Location.Longitude)
+	}
+
+	// maps are checked in sorted order so that errors are reported in
+	// the same order each time
+	for _, label := range sortedKeys(c.Devices) {
+		c.validateDevice(v, fmt.Sprintf("devices.%s", label), c.Devices[label])
+	}
+
+	for _, name := range sortedKeys(c.Groups) {
+		labels := c.Groups[name]
+		if len(labels) == 0 {
+			v.add(fmt.Sprintf("groups.%s", name), "must contain at least one device")
+		}
+		for i, label := range labels {
+			if _, ok := c.Devices[label]; !ok {
+				v.add(fmt.Sprintf("groups.%s[%d]", name, i), "references missing device %q", label)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.Calendars) {
+		_, err := calendar.Open(c.Calendars[name])
+		v.check(fmt.Sprintf("calendars.%s", name), err)
+	}
+
+	for _, name := range sortedKeys(c.Scenes) {
+		c.validateScene(v, fmt.Sprintf("scenes.%s", name), c.Scenes[name])
+	}
+
+	ids := make(map[string]bool)
+	for i, job := range c.Jobs {
+		path := fmt.Sprintf("jobs[%d]", i)
+		if job.ID != "" && ids[job.ID] {
+			v.add(path+".id", "duplicate id %q", job.ID)
+		}
+		ids[job.ID] = true
+
+		c.validateJob(v, path, job)
+	}
+
+	for i, circadian := range c.Circadian {
+		c.validateCircadian(v, fmt.Sprintf("circadian[%d]", i), circadian)
+	}
+
+	c.validateAway(v, "away")
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// ValidateJob checks a single job against the rest of the config, such as
+// one created through the HTTP API, and returns a ValidationError listing
+// all problems found, or nil if there are none
+func (c *Config) ValidateJob(job Job) error {
+	v := &validator{}
+	c.validateJob(v, "job", job)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (c *Config) validateDevice(v *validator, path string, device Device) {
+	known := false
+	for _, t := range DeviceTypes {
+		known = known || device.Type == t
+	}
+	if !known {
+		v.add(path+".type", "unknown device type %q, expected one of %s", device.Type, strings.Join(DeviceTypes, ", "))
+	}
+
+	// lifx bulbs can be located by their mac address alone
+	if device.Host == "" && device.Type != DeviceTypeLifx {
+		v.add(path+".host", "required")
+	}
+
+	if device.MAC == "" && device.Type == DeviceTypeLifx {
+		v.add(path+".mac", "required for lifx devices")
+	} else if device.MAC != "" {
+		_, err := net.ParseMAC(device.MAC)
+		v.check(path+".mac", err)
+	}
+
+	if index, ok := device.Config["index"]; ok && device.Type == DeviceTypeShelly {
+		if f, ok := index.(float64); !ok || f < 0 || f != float64(int(f)) {
+			v.add(path+".config.index", "must be a non-negative integer")
+		}
+	}
+}
+
+func (c *Config) validateScene(v *validator, path string, scene Scene) {
+	if len(scene) == 0 {
+		v.add(path, "must contain at least one device")
+	}
+
+	for _, label := range scene.Devices() {
+		state := scene[label]
+		if _, ok := c.Devices[label]; !ok {
+			v.add(path+"."+label, "references missing device %q", label)
+		}
+		validateState(v, path+"."+label, state)
+	}
+}
+
+func validateState(v *validator, path string, state State) {
+	validateColor(v, path, state)
+
+	if state.Transition == "" {
+		v.add(path+".transition", "required")
+	}
+	v.duration(path+".transition", state.Transition)
+}
+
+func validateColor(v *validator, path string, state State) {
+	v.inRange(path+".hue", state.Hue, 0, 360)
+	v.inRange(path+".saturation", state.Saturation, 0, 100)
+	v.inRange(path+".brightness", state.Brightness, 0, 100)
+	v.kelvin(path+".kelvin", state.Kelvin)
+}
+
+func (c *Config) validateJob(v *validator, path string, job Job) {
+	targets := 0
+	for _, target := range []string{job.Device, job.Group, job.Scene} {
+		if target != "" {
+			targets++
+		}
+	}
+
+	switch {
+	case targets > 1:
+		v.add(path, "must set only one of device, group, or scene")
+	case job.Scene != "":
+		if _, ok := c.Scenes[job.Scene]; !ok {
+			v.add(path+".scene", "references missing scene %q", job.Scene)
+		}
+	case job.Group != "":
+		if _, ok := c.Groups[job.Group]; !ok {
+			v.add(path+".group", "references missing group %q", job.Group)
+		}
+	case job.Device == "":
+		v.add(path+".device", "device, group, or scene is required")
+	default:
+		if _, ok := c.Devices[job.Device]; !ok {
+			v.add(path+".device", "references missing device %q", job.Device)
+		}
+	}
+
+	// the schedule can only be parsed once its options are valid
+	n := len(v.errs)
+	v.clock(path+".fallback", job.Fallback)
+	v.clock(path+".not_before", job.NotBefore)
+	v.clock(path+".not_after", job.NotAfter)
+	start, err := optionalDate(job.StartDate)
+	v.check(path+".start_date", err)
+	end, err := optionalDate(job.EndDate)
+	v.check(path+".end_date", err)
+	if len(v.errs) == n && !start.IsZero() && !end.IsZero() && end.Before(start) {
+		v.add(path+".end_date", "must not be before start_date")
+	}
+
+	// bounds don't wrap around midnight. Reversed bounds would clamp every
+	// firing to one of them, so they're rejected.
+	notBefore, _ := optionalClock(job.NotBefore)
+	notAfter, _ := optionalClock(job.NotAfter)
+	if notBefore != nil && notAfter != nil && *notAfter < *notBefore {
+		v.add(path+".not_after", "must not be before not_before")
+	}
+	if len(v.errs) == n {
+		_, err := job.ParseSchedule(c.Location)
+		v.check(path+".schedule", err)
+	}
+
+	if job.Zones != nil {
+		c.validateZones(v, path, job)
+	}
+	if job.Matrix != nil {
+		c.validateMatrix(v, path, job)
+	}
+
+	// scenes provide their own states, and effects have no transition
+	switch {
+	case job.Effect != nil:
+		c.validateEffect(v, path, job)
+	case job.Matrix != nil && job.Matrix.Effect != "":
+		// firmware effects choose their own colors
+	case job.Scene == "":
+		validateState(v, path, c.State(job, job.Device))
+	}
+	v.duration(path+".jitter", job.Jitter)
+
+	for i, exception := range job.Exceptions {
+		p := fmt.Sprintf("%s.exceptions[%d]", path, i)
+		if _, ok := c.Calendars[exception.Calendar]; !ok {
+			v.add(p+".calendar", "references missing calendar %q", exception.Calendar)
+		}
+		if _, ok := c.Scenes[exception.Scene]; exception.Scene != "" && !ok {
+			v.add(p+".scene", "references missing scene %q", exception.Scene)
+		}
+	}
+}
+
+func (c *Config) validateEffect(v *validator, path string, job Job) {
+	validateColor(v, path, c.State(job, job.Device))
+	if job.Scene != "" {
+		v.add(path+".effect", "can't be used with a scene")
+	}
+
+	// only lifx bulbs support waveforms
+	for _, label := range c.Targets(job) {
+		if device, ok := c.Devices[label]; ok && device.Type != DeviceTypeLifx {
+			v.add(path+".effect", "device %q is type %q, expected lifx", label, device.Type)
+		}
+	}
+
+	// names are matched as device.ParseWaveform does
+	switch strings.ToLower(job.Effect.Waveform) {
+	case "saw", "sine", "half-sine", "triangle", "pulse":
+	case "":
+		v.add(path+".effect.waveform", "required")
+	default:
+		v.add(path+".effect.waveform", "unknown waveform %q, expected one of saw, sine, half-sine, triangle, pulse", job.Effect.Waveform)
+	}
+
+	v.duration(path+".effect.period", job.Effect.Period)
+	if period, _, _, err := job.Effect.Parameters(); err == nil && period == 0 {
+		v.add(path+".effect.period", "must be positive")
+	}
+	if job.Effect.Cycles < 0 {
+		v.add(path+".effect.cycles", "must not be negative")
+	}
+	if skew := job.Effect.Skew; skew != nil && (*skew < 0 || *skew > 1) {
+		v.add(path+".effect.skew", "%g out of range [0, 1]", *skew)
+	}
+}
+
+func (c *Config) validateZones(v *validator, path string, job Job) {
+	if job.Scene != "" {
+		v.add(path+".zones", "can't be used with a scene")
+	}
+	if job.Effect != nil {
+		v.add(path+".zones", "can't be used with an effect")
+	}
+
+	// only lifx bulbs have zones, but whether a bulb is multizone is only
+	// known once it's connected
+	for _, label := range c.Targets(job) {
+		if device, ok := c.Devices[label]; ok && device.Type != DeviceTypeLifx {
+			v.add(path+".zones", "device %q is type %q, expected lifx", label, device.Type)
+		}
+	}
+
+	if len(job.Zones.Gradient) == 0 && len(job.Zones.Ranges) == 0 {
+		v.add(path+".zones", "must set a gradient or ranges")
+	}
+	for i, color := range job.Zones.Gradient {
+		validateColor(v, fmt.Sprintf("%s.zones.gradient[%d]", path, i), color.State())
+	}
+	for i, r := range job.Zones.Ranges {
+		p := fmt.Sprintf("%s.zones.ranges[%d]", path, i)
+		if r.Start < 0 {
+			v.add(p+".start", "must not be negative")
+		}
+		if r.End < r.Start {
+			v.add(p+".end", "must not be less than start")
+		}
+		validateColor(v, p, r.State())
+	}
+}
+
+func (c *Config) validateMatrix(v *validator, path string, job Job) {
+	if job.Scene != "" {
+		v.add(path+".matrix", "can't be used with a scene")
+	}
+	if job.Effect != nil {
+		v.add(path+".matrix", "can't be used with an effect")
+	}
+	if job.Zones != nil {
+		v.add(path+".matrix", "can't be used with zones")
+	}
+
+	// only lifx bulbs have matrices, but whether a bulb is a matrix is only
+	// known once it's connected
+	for _, label := range c.Targets(job) {
+		if device, ok := c.Devices[label]; ok && device.Type != DeviceTypeLifx {
+			v.add(path+".matrix", "device %q is type %q, expected lifx", label, device.Type)
+		}
+	}
+
+	matrix := job.Matrix
+	switch {
+	case matrix.Frame != "" && matrix.Effect != "":
+		v.add(path+".matrix", "must set only one of frame or effect")
+	case matrix.Frame != "":
+		_, err := frame.Open(matrix.Frame)
+		v.check(path+".matrix.frame", err)
+	case matrix.Effect == "":
+		v.add(path+".matrix", "must set a frame or effect")
+	}
+
+	switch strings.ToLower(matrix.Effect) {
+	case "", "flame", "morph", "off":
+	default:
+		v.add(path+".matrix.effect", "unknown effect %q, expected one of flame, morph, off", matrix.Effect)
+	}
+
+	v.duration(path+".matrix.speed", matrix.Speed)
+	v.duration(path+".matrix.duration", matrix.Duration)
+	if speed, _, err := matrix.Parameters(); err == nil && speed == 0 {
+		v.add(path+".matrix.speed", "must be positive")
+	}
+
+	if len(matrix.Palette) > 0 && !strings.EqualFold(matrix.Effect, "morph") {
+		v.add(path+".matrix.palette", "only applies to the morph effect")
+	}
+	if len(matrix.Palette) > MaxMatrixPalette {
+		v.add(path+".matrix.palette", "has %d colors, expected at most %d", len(matrix.Palette), MaxMatrixPalette)
+	}
+	for i, color := range matrix.Palette {
+		validateColor(v, fmt.Sprintf("%s.matrix.palette[%d]", path, i), color.State())
+	}
+}
+
+func (c *Config) validateCircadian(v *validator, path string, circadian Circadian) {
+	switch {
+	case circadian.Device != "" && circadian.Group != "":
+		v.add(path, "must set only one of device or group")
+	case circadian.Group != "":
+		if _, ok := c.Groups[circadian.Group]; !ok {
+			v.add(path+".group", "references missing group %q", circadian.Group)
+		}
+	case circadian.Device == "":
+		v.add(path+".device", "device or group is required")
+	}
+
+	// only lifx bulbs can change color temperature and brightness
+	for _, label := range c.CircadianTargets(circadian) {
+		device, ok := c.Devices[label]
+		if !ok && circadian.Device != "" {
+			v.add(path+".device", "references missing device %q", label)
+		} else if ok && device.Type != DeviceTypeLifx {
+			v.add(path, "device %q is type %q, expected lifx", label, device.Type)
+		}
+	}
+
+	v.inRange(path+".min_kelvin", circadian.MinKelvin, 1500, 9000)
+	v.inRange(path+".max_kelvin", circadian.MaxKelvin, 1500, 9000)
+	if circadian.MinKelvin > circadian.MaxKelvin {
+		v.add(path+".min_kelvin", "must not be greater than max_kelvin")
+	}
+
+	v.inRange(path+".min_brightness", circadian.MinBrightness, 0, 100)
+	v.inRange(path+".max_brightness", circadian.MaxBrightness, 0, 100)
+	if circadian.MinBrightness > circadian.MaxBrightness {
+		v.add(path+".min_brightness", "must not be greater than max_brightness")
+	}
+
+	curve := circadian.Curve(c.Location)
+	if curve.Low < -90 || curve.Low > 90 {
+		v.add(path+".low_elevation", "%g out of range [-90, 90]", curve.Low)
+	}
+	if curve.High < -90 || curve.High > 90 {
+		v.add(path+".high_elevation", "%g out of range [-90, 90]", curve.High)
+	}
+	if curve.Low >= curve.High {
+		v.add(path+".low_elevation", "must be less than high_elevation")
+	}
+
+	v.duration(path+".interval", circadian.Interval)
+	v.duration(path+".pause", circadian.Pause)
+	if interval, _, err := circadian.Durations(); err == nil && interval < time.Second {
+		v.add(path+".interval", "must be at least 1s")
+	}
+}
+
+func (c *Config) validateAway(v *validator, path string) {
+	for i, label := range c.Away.Devices {
+		if _, ok := c.Devices[label]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "references missing device %q", label)
+		}
+	}
+
+	if c.Away.Events < 0 {
+		v.add(path+".events", "must not be negative")
+	}
+
+	if c.Away.Enabled && c.Away.Events > 0 {
+		if c.Away.Start == "" {
+			v.add(path+".start", "required when away mode has events")
+		}
+		if c.Away.End == "" {
+			v.add(path+".end", "required when away mode has events")
+		}
+		// zero would switch devices off for each on event
+		if c.Away.Brightness == 0 {
+			v.add(path+".brightness", "required when away mode has events")
+		}
+	}
+	v.clock(path+".start", c.Away.Start)
+	v.clock(path+".end", c.Away.End)
+
+	v.duration(path+".jitter", c.Away.Jitter)
+	v.duration(path+".transition", c.Away.Transition)
+	v.inRange(path+".brightness", c.Away.Brightness, 0, 100)
+	v.kelvin(path+".kelvin", c.Away.Kelvin)
+}
+
+// position returns the line and column at which a decoding error
+// occurred in the file
+func position(f *os.File, err error) (int, int, bool) {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0, 0, false
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	b := make([]byte, offset)
+	n, _ := io.ReadFull(f, b)
+	before := b[:n]
+
+	line := 1 + strings.Count(string(before), "\n")
+	col := len(before) - strings.LastIndex(string(before), "\n")
+	return line, col, true
+}
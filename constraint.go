@@ -0,0 +1,216 @@
+package lamplighter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const betweenKeyword = "between"
+
+var (
+	weekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+)
+
+// MonthDay is a day of the year, independent of year
+type MonthDay struct {
+	Month time.Month `json:"month"`
+	Day   int        `json:"day"`
+}
+
+func (m MonthDay) String() string {
+	return fmt.Sprintf("%02d-%02d", m.Month, m.Day)
+}
+
+// before reports whether m falls earlier in the year than other
+func (m MonthDay) before(other MonthDay) bool {
+	return m.Month < other.Month || (m.Month == other.Month && m.Day < other.Day)
+}
+
+// DateRange is an inclusive range of days of the year. If From falls
+// later in the year than To, the range wraps around the new year.
+type DateRange struct {
+	From MonthDay `json:"from"`
+	To   MonthDay `json:"to"`
+}
+
+func (r DateRange) Contains(t time.Time) bool {
+	day := MonthDay{Month: t.Month(), Day: t.Day()}
+	if r.To.before(r.From) {
+		return !day.before(r.From) || !r.To.before(day)
+	}
+	return !day.before(r.From) && !r.To.before(day)
+}
+
+// ConstrainedSchedule limits the days on which a schedule fires by day of
+// the week, month, and date range. Zero values for Weekdays and Months and
+// a nil Between place no constraint on those fields.
+type ConstrainedSchedule struct {
+	Schedule cron.Schedule `json:"schedule"`
+	Weekdays uint8         `json:"weekdays"` // bit n set for time.Weekday(n)
+	Months   uint16        `json:"months"`   // bit n set for time.Month(n)
+	Between  *DateRange    `json:"between,omitempty"`
+}
+
+// Next returns the first activation time of the underlying schedule
+// after now which satisfies the constraints
+//
+// This implements robfig/cron.Schedule
+func (s ConstrainedSchedule) Next(now time.Time) time.Time {
+	next, _ := s.next(now)
+	return next
+}
+
+// IsFallback reports whether the next activation time after now is the
+// underlying schedule's fallback time
+//
+// This implements FallbackSchedule
+func (s ConstrainedSchedule) IsFallback(now time.Time) bool {
+	return wrappedFallback(s.Schedule, s.next, now)
+}
+
+// next returns the next matching activation time and the time from which
+// the underlying schedule produced it
+func (s ConstrainedSchedule) next(now time.Time) (next, from time.Time) {
+	from = now
+	for i := 0; i < maxSearchDays; i++ {
+		next = s.Schedule.Next(from)
+		if next.IsZero() {
+			break
+		}
+
+		if s.matches(next.In(now.Location())) {
+			return next, from
+		}
+		from = next
+	}
+
+	return time.Time{}, time.Time{}
+}
+
+func (s ConstrainedSchedule) matches(t time.Time) bool {
+	if s.Weekdays != 0 && s.Weekdays&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	if s.Months != 0 && s.Months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	if s.Between != nil && !s.Between.Contains(t) {
+		return false
+	}
+	return true
+}
+
+// parseConstraint parses the day constraints which may follow a solar
+// schedule's offset: a comma separated list of weekdays or weekday
+// ranges, such as "mon-fri" or "sat,sun", a list of months or month
+// ranges, such as "nov-feb", and a date range in the form
+// "between MM-DD and MM-DD"
+func parseConstraint(schedule cron.Schedule, args []string) (cron.Schedule, error) {
+	if len(args) == 0 {
+		return schedule, nil
+	}
+
+	constrained := ConstrainedSchedule{
+		Schedule: schedule,
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(args[i])
+
+		if arg == betweenKeyword {
+			if len(args) < i+4 || strings.ToLower(args[i+2]) != "and" {
+				return nil, fmt.Errorf("expected \"between MM-DD and MM-DD\"")
+			}
+			from, err := parseMonthDay(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			to, err := parseMonthDay(args[i+3])
+			if err != nil {
+				return nil, err
+			}
+			constrained.Between = &DateRange{From: from, To: to}
+			i += 3
+			continue
+		}
+
+		if bits, err := parseNamedList(arg, weekdayNames, 0, 6); err == nil {
+			constrained.Weekdays |= uint8(bits)
+			continue
+		}
+
+		if bits, err := parseNamedList(arg, monthNames, 1, 12); err == nil {
+			constrained.Months |= uint16(bits)
+			continue
+		}
+
+		return nil, fmt.Errorf("unknown schedule constraint %q", args[i])
+	}
+
+	return constrained, nil
+}
+
+// parseNamedList parses a comma separated list of names and name ranges
+// into a bitset. Ranges wrap around if the end precedes the start, so
+// "fri-mon" includes the weekend.
+func parseNamedList(s string, names map[string]int, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		start, ok := names[bounds[0]]
+		if !ok {
+			return 0, fmt.Errorf("unknown name %q", bounds[0])
+		}
+
+		end := start
+		if len(bounds) > 1 {
+			end, ok = names[bounds[1]]
+			if !ok {
+				return 0, fmt.Errorf("unknown name %q", bounds[1])
+			}
+		}
+
+		for n := start; ; n++ {
+			if n > max {
+				n = min
+			}
+			bits |= 1 << uint(n)
+			if n == end {
+				break
+			}
+		}
+	}
+
+	return bits, nil
+}
+
+// parseMonthDay parses a day of the year in the format MM-DD
+func parseMonthDay(s string) (MonthDay, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return MonthDay{}, fmt.Errorf("parse date %q: expected format MM-DD", s)
+	}
+
+	month, err := strconv.Atoi(parts[0])
+	if err != nil || month < 1 || month > 12 {
+		return MonthDay{}, fmt.Errorf("parse date %q: invalid month", s)
+	}
+
+	day, err := strconv.Atoi(parts[1])
+	if err != nil || day < 1 || day > 31 {
+		return MonthDay{}, fmt.Errorf("parse date %q: invalid day", s)
+	}
+
+	return MonthDay{Month: time.Month(month), Day: day}, nil
+}
@@ -0,0 +1,90 @@
+package lamplighter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DateLayout is the format of the dates which bound a DatedSchedule
+const DateLayout = "2006-01-02"
+
+// atLayouts are the formats accepted by the @at descriptor. Times without
+// a zone are in the local time zone.
+var atLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// AtSchedule fires once, at Time
+type AtSchedule struct {
+	Time time.Time `json:"time"`
+}
+
+// Next returns Time if it is after now and the zero time otherwise
+//
+// This implements robfig/cron.Schedule
+func (s AtSchedule) Next(now time.Time) time.Time {
+	if s.Time.After(now) {
+		return s.Time
+	}
+	return time.Time{}
+}
+
+// ParseAt parses the time of a one-shot schedule, such as
+// "2026-12-24T17:00"
+func ParseAt(s string) (time.Time, error) {
+	for _, layout := range atLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse time %q: expected format %s", s, atLayouts[0])
+}
+
+// DatedSchedule limits a schedule to the days from Start through End,
+// inclusive. Either date may be zero to leave that side unbounded.
+type DatedSchedule struct {
+	Schedule cron.Schedule `json:"schedule"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+}
+
+// Next returns the first activation time of the underlying schedule after
+// now which falls within the date range
+//
+// This implements robfig/cron.Schedule
+func (s DatedSchedule) Next(now time.Time) time.Time {
+	next, _ := s.next(now)
+	return next
+}
+
+// IsFallback reports whether the next activation time after now is the
+// underlying schedule's fallback time
+//
+// This implements FallbackSchedule
+func (s DatedSchedule) IsFallback(now time.Time) bool {
+	return wrappedFallback(s.Schedule, s.next, now)
+}
+
+// next returns the next activation time within the date range and the
+// time from which the underlying schedule produced it
+func (s DatedSchedule) next(now time.Time) (next, from time.Time) {
+	from = now
+	if start := s.Start.Add(-time.Nanosecond); !s.Start.IsZero() && start.After(from) {
+		from = start
+	}
+
+	next = s.Schedule.Next(from)
+	if next.IsZero() {
+		return time.Time{}, time.Time{}
+	}
+	if !s.End.IsZero() && !next.Before(s.End.AddDate(0, 0, 1)) {
+		return time.Time{}, time.Time{}
+	}
+
+	return next, from
+}
@@ -13,14 +13,20 @@ const (
 	defaultPowerTransition = 2 * time.Second
 	defaultRetryBackoff    = 250 * time.Millisecond
 	defaultRetryLimit      = 5
+
+	// bulbs located by discovery are rediscovered at most once per
+	// backoff, which doubles between these durations while they can't be
+	// found
+	minRediscoverBackoff = 5 * time.Second
+	maxRediscoverBackoff = 5 * time.Minute
 )
 
 type Type string
 
 const (
-	TypeLifx   Type = "lifx"
-	TypeS31    Type = "s31"
-	TypeShelly Type = "shelly"
+	TypeLifx   Type = config.DeviceTypeLifx
+	TypeS31    Type = config.DeviceTypeS31
+	TypeShelly Type = config.DeviceTypeShelly
 )
 
 type Color struct {
@@ -34,14 +40,27 @@ type Device interface {
 	StatusHandler(http.ResponseWriter, *http.Request)
 	PowerHandler(http.ResponseWriter, *http.Request)
 	Transition(*Color, time.Duration) error
+	Status() (*Color, error)
 	Label() string
 	String() string
 }
 
+// Fader is implemented by devices which transition gradually to a new
+// color over the transition duration, rather than switching immediately.
+// Fades reports whether the device is currently able to do so.
+type Fader interface {
+	Device
+	Fades() bool
+}
+
 func Connect(label string, device config.Device) (Device, error) {
 	switch Type(device.Type) {
 	case TypeLifx:
-		addr := fmt.Sprintf("%s:%d", device.Host, defaultLifxPort)
+		// lifx bulbs without a host are located by discovery
+		var addr string
+		if device.Host != "" {
+			addr = fmt.Sprintf("%s:%d", device.Host, defaultLifxPort)
+		}
 		return ConnectLifx(label, addr, device.MAC)
 	case TypeS31:
 		return ConnectS31(label, device.Host, device.MAC)
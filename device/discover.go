@@ -0,0 +1,177 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.yhsif.com/lifxlan"
+)
+
+// DefaultDiscoveryTimeout is how long to listen for LIFX devices
+// responding to a discovery broadcast
+const DefaultDiscoveryTimeout = 3 * time.Second
+
+// DiscoveredLifx describes a LIFX device found on the local network
+type DiscoveredLifx struct {
+	Label   string `json:"label"`
+	MAC     string `json:"mac"`
+	Host    string `json:"host"` // ip:port
+	Product string `json:"product"`
+}
+
+// IP returns the device's IP address, without its port
+func (d DiscoveredLifx) IP() string {
+	host, _, err := net.SplitHostPort(d.Host)
+	if err != nil {
+		return d.Host
+	}
+	return host
+}
+
+// discoverMu serializes discovery, as each broadcast listens on the same
+// udp port and a second bind fails while one is in progress
+var discoverMu sync.Mutex
+
+// discover broadcasts a discovery message and calls found with each
+// device which responds until timeout elapses or found returns false.
+// The timeout starts once any other discovery has finished.
+func discover(timeout time.Duration, found func(lifxlan.Device, string) bool) error {
+	discoverMu.Lock()
+	defer discoverMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	devices := make(chan lifxlan.Device)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- lifxlan.Discover(ctx, devices, "")
+	}()
+
+	for dev := range devices {
+		// dialing udp doesn't contact the device, but it does expose the
+		// address the device responded from
+		conn, err := dev.Dial()
+		if err != nil {
+			continue
+		}
+		addr := conn.RemoteAddr().String()
+		conn.Close()
+
+		if !found(dev, addr) {
+			cancel()
+		}
+	}
+
+	err := <-errs
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("discover: %w", err)
+	}
+	return nil
+}
+
+// DiscoverLifx lists the LIFX devices which respond to a discovery
+// broadcast within timeout, sorted by label
+func DiscoverLifx(timeout time.Duration) ([]DiscoveredLifx, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[lifxlan.Target]bool)
+	var discovered []DiscoveredLifx
+
+	err := discover(timeout, func(dev lifxlan.Device, addr string) bool {
+		// devices respond once for each service they offer
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[dev.Target()] {
+			return true
+		}
+		seen[dev.Target()] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := describeLifx(dev, addr)
+
+			mu.Lock()
+			discovered = append(discovered, d)
+			mu.Unlock()
+		}()
+		return true
+	})
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(discovered, func(i, j int) bool {
+		if discovered[i].Label == discovered[j].Label {
+			return discovered[i].MAC < discovered[j].MAC
+		}
+		return discovered[i].Label < discovered[j].Label
+	})
+	return discovered, nil
+}
+
+// describeLifx queries a discovered device for its label and product.
+// Fields which can't be queried are left empty.
+func describeLifx(dev lifxlan.Device, addr string) DiscoveredLifx {
+	d := DiscoveredLifx{
+		MAC:  dev.Target().String(),
+		Host: addr,
+	}
+
+	conn, err := dev.Dial()
+	if err != nil {
+		return d
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := dev.GetLabel(ctx, conn); err == nil && dev.Label().String() != lifxlan.EmptyLabel {
+		d.Label = strings.ToLower(dev.Label().String())
+	}
+	if err := dev.GetHardwareVersion(ctx, conn); err == nil {
+		if product := dev.HardwareVersion().Parse(); product != nil {
+			d.Product = product.ProductName
+		}
+	}
+
+	return d
+}
+
+// ResolveLifx finds the address, in ip:port format, of the LIFX device
+// with the given mac address by broadcasting a discovery message
+func ResolveLifx(mac string, timeout time.Duration) (string, error) {
+	target, err := lifxlan.ParseTarget(mac)
+	if err != nil {
+		return "", fmt.Errorf("parse mac address: %w", err)
+	}
+
+	var host string
+	err = discover(timeout, func(dev lifxlan.Device, addr string) bool {
+		if dev.Target() == target {
+			host = addr
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", fmt.Errorf("device %s not found on the network", mac)
+	}
+
+	return host, nil
+}
@@ -0,0 +1,310 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/config"
+
+	"go.yhsif.com/lifxlan"
+	"go.yhsif.com/lifxlan/light"
+)
+
+// Waveform is the shape of an effect's change in color over each period
+// https://lan.developer.lifx.com/docs/waveforms
+type Waveform string
+
+const (
+	WaveformSaw      Waveform = "saw"
+	WaveformSine     Waveform = "sine"
+	WaveformHalfSine Waveform = "half-sine"
+	WaveformTriangle Waveform = "triangle"
+	WaveformPulse    Waveform = "pulse"
+)
+
+// Waveforms lists the supported waveforms
+var Waveforms = []Waveform{WaveformSaw, WaveformSine, WaveformHalfSine, WaveformTriangle, WaveformPulse}
+
+// ParseWaveform returns the waveform with the given name
+func ParseWaveform(s string) (Waveform, error) {
+	for _, w := range Waveforms {
+		if strings.EqualFold(s, string(w)) {
+			return w, nil
+		}
+	}
+
+	names := make([]string, len(Waveforms))
+	for i, w := range Waveforms {
+		names[i] = string(w)
+	}
+	return "", fmt.Errorf("unknown waveform %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+func (w Waveform) lifx() light.Waveform {
+	switch w {
+	case WaveformSaw:
+		return light.WaveformSaw
+	case WaveformHalfSine:
+		return light.WaveformHalfSine
+	case WaveformTriangle:
+		return light.WaveformTriangle
+	case WaveformPulse:
+		return light.WaveformPulse
+	default:
+		return light.WaveformSine
+	}
+}
+
+// Effect cycles a device between its current color and Color. Unless
+// Persist is set, the device returns to its original color afterward.
+type Effect struct {
+	Waveform Waveform
+	Color    *Color
+	Period   time.Duration // duration of each cycle
+	Cycles   float64
+
+	// Skew is the fraction of each pulse cycle spent at the original
+	// color, between 0 and 1. It only applies to the pulse waveform.
+	Skew float64
+
+	Persist bool
+}
+
+// Duration returns how long the effect runs
+func (e *Effect) Duration() time.Duration {
+	return time.Duration(float64(e.Period) * e.Cycles)
+}
+
+// Effector is implemented by devices which can run waveform effects
+type Effector interface {
+	Device
+	Effect(*Effect) error
+}
+
+// Effect runs the waveform effect on the bulb
+//
+// This implements Effector
+func (d *LifxBulb) Effect(effect *Effect) error {
+	err := d.effect(effect)
+	if err != nil && d.rediscover() {
+		err = d.effect(effect)
+	}
+	return err
+}
+
+func (d *LifxBulb) effect(effect *Effect) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	conn, err := d.Dial()
+	if err != nil {
+		return fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = d.echo(ctx, conn, nil)
+	if err != nil {
+		return fmt.Errorf("%s: echo device: %w", d.label, err)
+	}
+
+	color := d.Device.SanitizeColor(lifxlan.Color{
+		Hue:        effect.Color.Hue,
+		Saturation: effect.Color.Saturation,
+		Brightness: effect.Color.Brightness,
+		Kelvin:     effect.Color.Kelvin,
+	})
+
+	err = d.SetWaveform(ctx, conn, &light.SetWaveformArgs{
+		Transient: !effect.Persist,
+		Color:     &color,
+		Period:    effect.Period,
+		Cycles:    float32(effect.Cycles),
+		Waveform:  effect.Waveform.lifx(),
+		SkewRatio: effect.Skew,
+	}, true)
+	if err != nil {
+		return fmt.Errorf("%s: set waveform: %w", d.label, err)
+	}
+
+	return nil
+}
+
+// ParseEffectParams parses the waveform, color, period, cycles, skew, and
+// persist parameters used by device effect handlers. The color may be
+// given as a single color parameter, as accepted by ParseColor, or with
+// the hue, saturation, brightness, and kelvin parameters, in which case
+// brightness defaults to 100.
+func ParseEffectParams(r *http.Request) (*Effect, error) {
+	r.ParseForm()
+
+	if _, ok := r.Form["waveform"]; !ok {
+		return nil, fmt.Errorf("waveform parameter is required")
+	}
+	waveform, err := ParseWaveform(r.FormValue("waveform"))
+	if err != nil {
+		return nil, err
+	}
+
+	effect := &Effect{
+		Waveform: waveform,
+		Period:   config.DefaultEffectPeriod,
+		Cycles:   config.DefaultEffectCycles,
+		Skew:     config.DefaultEffectSkew,
+	}
+
+	if _, ok := r.Form["color"]; ok {
+		param := r.FormValue("color")
+		effect.Color, err = ParseColor(param)
+		if err != nil {
+			return nil, &ParamError{Param: "color", Value: param, Err: err}
+		}
+	} else {
+		if _, ok := r.Form["brightness"]; !ok {
+			r.Form.Set("brightness", "100")
+		}
+		effect.Color, _, err = ParseColorParams(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := r.Form["period"]; ok {
+		param := r.FormValue("period")
+		_, err := strconv.Atoi(param)
+		if err == nil && param != "" {
+			param = param + "ms"
+		}
+
+		effect.Period, err = time.ParseDuration(param)
+		if err != nil || effect.Period <= 0 {
+			return nil, &ParamError{Param: "period", Value: param, Err: fmt.Errorf("must be a positive duration")}
+		}
+	}
+
+	if _, ok := r.Form["cycles"]; ok {
+		param := r.FormValue("cycles")
+		effect.Cycles, err = strconv.ParseFloat(param, 64)
+		if err != nil || effect.Cycles <= 0 {
+			return nil, &ParamError{Param: "cycles", Value: param, Err: fmt.Errorf("must be a positive number")}
+		}
+	}
+
+	if _, ok := r.Form["skew"]; ok {
+		param := r.FormValue("skew")
+		skew, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, &ParamError{Param: "skew", Value: param, Err: err}
+		}
+		effect.Skew = math.Max(0, math.Min(skew, 1))
+	}
+
+	if _, ok := r.Form["persist"]; ok {
+		param := r.FormValue("persist")
+		effect.Persist, err = strconv.ParseBool(param)
+		if err != nil {
+			return nil, &ParamError{Param: "persist", Value: param, Err: err}
+		}
+	}
+
+	return effect, nil
+}
+
+// ParseColor parses a color given either as a hex RGB string, such as
+// "#ff8000", or as space separated key:value pairs of hue (0-360),
+// saturation (0-100), brightness (0-100), and kelvin, such as
+// "hue:120 saturation:100 brightness:50". Brightness defaults to 100
+// when using key:value pairs.
+func ParseColor(s string) (*Color, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		return parseHexColor(strings.TrimPrefix(s, "#"))
+	}
+
+	hsbk := map[string]float64{"brightness": 100}
+	for _, field := range strings.Fields(s) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected key:value, got %q", field)
+		}
+
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", key, err)
+		}
+
+		switch key {
+		case "hue":
+			f = math.Max(0, math.Min(f, 360))
+		case "saturation", "brightness":
+			f = math.Max(0, math.Min(f, 100))
+		case "kelvin":
+			f = math.Max(1500, math.Min(f, 9000))
+		default:
+			return nil, fmt.Errorf("unknown color field %q", key)
+		}
+		hsbk[key] = f
+	}
+
+	return &Color{
+		Hue:        uint16(math.Floor((hsbk["hue"] / 360.0) * float64(math.MaxUint16))),
+		Saturation: uint16(math.Floor((hsbk["saturation"] / 100.0) * float64(math.MaxUint16))),
+		Brightness: uint16(math.Floor((hsbk["brightness"] / 100.0) * float64(math.MaxUint16))),
+		Kelvin:     uint16(hsbk["kelvin"]),
+	}, nil
+}
+
+// parseHexColor converts a hex RGB color, such as "ff8000", to HSB
+func parseHexColor(s string) (*Color, error) {
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return nil, fmt.Errorf("expected six hex digits, got %q", s)
+	}
+
+	return rgbColor(
+		float64(rgb>>16&0xff)/0xff,
+		float64(rgb>>8&0xff)/0xff,
+		float64(rgb&0xff)/0xff,
+	), nil
+}
+
+// rgbColor converts red, green, and blue components, each from 0 to 1,
+// to HSB
+func rgbColor(r, g, b float64) *Color {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case max == r:
+		hue = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		hue = 60 * ((b-r)/delta + 2)
+	default:
+		hue = 60 * ((r-g)/delta + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+
+	var saturation float64
+	if max > 0 {
+		saturation = delta / max
+	}
+
+	return &Color{
+		Hue:        uint16(math.Floor((hue / 360.0) * float64(math.MaxUint16))),
+		Saturation: uint16(math.Floor(saturation * float64(math.MaxUint16))),
+		Brightness: uint16(math.Floor(max * float64(math.MaxUint16))),
+	}
+}
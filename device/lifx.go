@@ -8,23 +8,69 @@ import (
 	"math"
 	"net"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.yhsif.com/lifxlan"
 	"go.yhsif.com/lifxlan/light"
+	"go.yhsif.com/lifxlan/tile"
 )
 
 type LifxBulb struct {
 	light.Device
 	label string // prevent need to contact device for logging
+
+	// bulbs configured without a host are located by discovery, both when
+	// connecting and again whenever the bulb stops responding
+	mac      string
+	discover bool
+	mu       sync.RWMutex // guards Device and label while they're being replaced
+
+	// rediscovery broadcasts at most once per backoff window, which grows
+	// while the bulb can't be found
+	discoverMu      sync.Mutex
+	discoverAt      time.Time // earliest time to broadcast again
+	discoverBackoff time.Duration
 }
 
 // ConnectLifx takes a label (for logging), a host in ip:port
 // format and a mac address to locate a device on the network, connect
-// to it, and retrieve the label and hardware version
+// to it, and retrieve the label and hardware version. If host is empty,
+// the device is located by broadcasting a discovery message.
 func ConnectLifx(label, host, mac string) (Device, error) {
+	discover := host == ""
+	if discover {
+		var err error
+		host, err = ResolveLifx(mac, DefaultDiscoveryTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("%s: resolve address: %w", label, err)
+		}
+	}
+
+	bulb, err := dialLifx(label, host, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &LifxBulb{
+		Device:   bulb,
+		mac:      mac,
+		discover: discover,
+	}
+
+	// bulbs without a label of their own are logged by their config label
+	device.label = label
+	if device.Device.Label().String() != lifxlan.EmptyLabel {
+		device.label = strings.ToLower(device.Device.Label().String())
+	}
+
+	return device, nil
+}
+
+// dialLifx connects to the bulb at host and retrieves its label and
+// hardware version
+func dialLifx(label, host, mac string) (light.Device, error) {
 	target, err := lifxlan.ParseTarget(mac)
 	if err != nil {
 		return nil, fmt.Errorf("%s: parse mac address: %w", label, err)
@@ -55,20 +101,88 @@ func ConnectLifx(label, host, mac string) (Device, error) {
 		return nil, fmt.Errorf("%s: device is not a light: %w", label, err)
 	}
 
-	device := &LifxBulb{
-		Device: bulb,
+	err = bulb.GetHardwareVersion(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: get hardware version: %w", label, err)
 	}
 
-	err = device.GetHardwareVersion(ctx, conn)
+	// the firmware version determines which features, such as extended
+	// multizone messages, the bulb supports
+	err = bulb.GetFirmware(ctx, conn)
 	if err != nil {
-		return nil, fmt.Errorf("%s: get hardware version: %w", label, err)
+		return nil, fmt.Errorf("%s: get firmware: %w", label, err)
 	}
 
-	if device.Device.Label().String() != lifxlan.EmptyLabel {
-		device.label = strings.ToLower(device.Device.Label().String())
+	// matrix devices, such as tiles and candles, are wrapped so that their
+	// pixels can be set individually
+	product := bulb.HardwareVersion().Parse()
+	if product != nil && product.FeaturesAt(*bulb.Firmware()).Matrix.Get() {
+		matrix, err := tile.Wrap(ctx, bulb, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: get device chain: %w", label, err)
+		}
+		return matrix, nil
 	}
 
-	return device, nil
+	return bulb, nil
+}
+
+// rediscover locates a bulb configured without a host again, in case its
+// address has changed, and reports whether the bulb was found. Discovery
+// blocks while it waits for responses, so each bulb broadcasts at most
+// once per backoff window and concurrent callers don't wait for it.
+func (d *LifxBulb) rediscover() bool {
+	if !d.discover || !d.discoverMu.TryLock() {
+		return false
+	}
+	defer d.discoverMu.Unlock()
+
+	now := time.Now()
+	if now.Before(d.discoverAt) {
+		return false
+	}
+
+	found := d.resolve()
+	if found || d.discoverBackoff == 0 {
+		d.discoverBackoff = minRediscoverBackoff
+	} else {
+		d.discoverBackoff *= 2
+	}
+	if d.discoverBackoff > maxRediscoverBackoff {
+		d.discoverBackoff = maxRediscoverBackoff
+	}
+	d.discoverAt = now.Add(d.discoverBackoff)
+
+	return found
+}
+
+// resolve broadcasts for the bulb and replaces the connected device,
+// along with its label, if the bulb is found
+func (d *LifxBulb) resolve() bool {
+	host, err := ResolveLifx(d.mac, DefaultDiscoveryTimeout)
+	if err != nil {
+		log.Printf("ERR: %s: resolve address: %s", d.label, err)
+		return false
+	}
+
+	bulb, err := dialLifx(d.label, host, d.mac)
+	if err != nil {
+		log.Printf("ERR: %s", err)
+		return false
+	}
+
+	label := d.label
+	if bulb.Label().String() != lifxlan.EmptyLabel {
+		label = strings.ToLower(bulb.Label().String())
+	}
+
+	d.mu.Lock()
+	d.Device = bulb
+	d.label = label
+	d.mu.Unlock()
+
+	log.Printf("resolved address %s: %s", host, label)
+	return true
 }
 
 // echo wraps the underlying method of the same name and adds retry logic
@@ -88,6 +202,17 @@ func (d *LifxBulb) echo(ctx context.Context, conn net.Conn, payload []byte) erro
 }
 
 func (d *LifxBulb) Transition(color *Color, transition time.Duration) error {
+	err := d.transition(color, transition)
+	if err != nil && d.rediscover() {
+		err = d.transition(color, transition)
+	}
+	return err
+}
+
+func (d *LifxBulb) transition(color *Color, transition time.Duration) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	conn, err := d.Dial()
 	if err != nil {
 		return fmt.Errorf("%s: dial: %w", d.label, err)
@@ -147,7 +272,63 @@ func (d *LifxBulb) Transition(color *Color, transition time.Duration) error {
 	return nil
 }
 
+// Status returns the current color of the bulb. If the bulb is powered
+// off, its brightness is reported as zero.
+func (d *LifxBulb) Status() (*Color, error) {
+	status, err := d.status()
+	if err != nil && d.rediscover() {
+		status, err = d.status()
+	}
+	return status, err
+}
+
+func (d *LifxBulb) status() (*Color, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	conn, err := d.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	power, err := d.GetPower(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: get power: %w", d.label, err)
+	}
+
+	color, err := d.GetColor(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: get color: %w", d.label, err)
+	}
+
+	status := &Color{
+		Hue:        color.Hue,
+		Saturation: color.Saturation,
+		Brightness: color.Brightness,
+		Kelvin:     color.Kelvin,
+	}
+	if power == lifxlan.PowerOff {
+		status.Brightness = 0
+	}
+
+	return status, nil
+}
+
+// Fades reports that LIFX bulbs transition gradually between colors
+//
+// This implements Fader
+func (d *LifxBulb) Fades() bool {
+	return true
+}
+
 func (d *LifxBulb) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	conn, err := d.Dial()
 	if err != nil {
 		log.Printf("ERR: %s: dial: %s", d.label, err)
@@ -168,128 +349,38 @@ func (d *LifxBulb) StatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// multizone bulbs also report the color of each zone
+	var zones string
+	if d.multizone() == nil {
+		colors, err := d.getZones(ctx, conn)
+		if err != nil {
+			log.Printf("ERR: %s: get zones: %s", d.label, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "unable to get device zone state"}`))
+			return
+		}
+		zones = fmt.Sprintf(`, "zones": %s`, ColorsJSON(colors))
+	}
+
 	fmt.Fprintf(
 		w,
-		`{"hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d}`,
+		`{"hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d%s}`,
 		float64(color.Hue)*360.0/0x10000,
 		float64(color.Saturation)/math.MaxUint16*100,
 		float64(color.Brightness)/math.MaxUint16*100,
 		color.Kelvin,
+		zones,
 	)
 }
 
 func (d *LifxBulb) PowerHandler(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-
-	var hue uint16
-	if _, ok := r.Form["hue"]; ok {
-		param := r.FormValue("hue")
-		p, err := strconv.ParseFloat(param, 64)
-		if err != nil {
-			log.Printf("ERR: %s: parse hue param %q: %s", d.label, param, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error": "unable to parse hue parameter"}`))
-			return
-		}
-
-		if p < 0 {
-			p = 0
-		} else if p > 360 {
-			p = 360
-		}
-
-		hue = uint16(math.Floor((p / 360.0) * float64(math.MaxUint16)))
-	}
-
-	var saturation uint16
-	if _, ok := r.Form["saturation"]; ok {
-		param := r.FormValue("saturation")
-		p, err := strconv.ParseFloat(param, 64)
-		if err != nil {
-			log.Printf("ERR: %s: parse saturation param %q: %s", d.label, param, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error": "unable to parse saturation parameter"}`))
-			return
-		}
-
-		if p < 0 {
-			p = 0
-		} else if p > 100 {
-			p = 100
-		}
-
-		saturation = uint16(math.Floor((p / 100.0) * float64(math.MaxUint16)))
-	}
-
-	var brightness uint16
-	if _, ok := r.Form["brightness"]; ok {
-		param := r.FormValue("brightness")
-		p, err := strconv.ParseFloat(param, 64)
-		if err != nil {
-			log.Printf("ERR: %s: parse brightness param %q: %s", d.label, param, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error": "unable to parse brightness parameter"}`))
-			return
-		}
-
-		if p < 0 {
-			p = 0
-		} else if p > 100 {
-			p = 100
-		}
-
-		brightness = uint16(math.Floor((p / 100.0) * float64(math.MaxUint16)))
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error": "brightness parameter is required"}`))
-	}
-
-	var kelvin uint16
-	if _, ok := r.Form["kelvin"]; ok {
-		param := r.FormValue("kelvin")
-		p, err := strconv.Atoi(param)
-		if err != nil {
-			log.Printf("ERR: %s: parse kelvin param %q: %s", d.label, param, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error": "unable to parse kelvin parameter"}`))
-			return
-		}
-
-		if p < 1500 {
-			p = 1500
-		} else if p > 9000 {
-			p = 9000
-		}
-
-		kelvin = uint16(p)
-	}
-
-	transition := defaultPowerTransition
-	if _, ok := r.Form["transition"]; ok {
-		param := r.FormValue("transition")
-		_, err := strconv.Atoi(param)
-		if err == nil && param != "" {
-			param = param + "ms"
-		}
-
-		parsed, err := time.ParseDuration(param)
-		if err != nil {
-			log.Printf("ERR: %s: parse transition param %q: %s", d.label, param, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"error": "unable to parse transition parameter"}`))
-			return
-		}
-		transition = parsed
-	}
-
-	color := &Color{
-		Hue:        hue,
-		Saturation: saturation,
-		Brightness: brightness,
-		Kelvin:     kelvin,
+	color, transition, err := ParseColorParams(r)
+	if err != nil {
+		WriteParamError(w, d.label, err)
+		return
 	}
 
-	err := d.Transition(color, transition)
+	err = d.Transition(color, transition)
 	if err != nil {
 		log.Printf("ERR: transition: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -309,9 +400,13 @@ func (d *LifxBulb) PowerHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *LifxBulb) Label() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.label
 }
 
 func (d *LifxBulb) String() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.Device.HardwareVersion().String()
 }
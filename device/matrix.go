@@ -0,0 +1,335 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/subtlepseudonym/lamplighter/frame"
+
+	"go.yhsif.com/lifxlan"
+	"go.yhsif.com/lifxlan/tile"
+)
+
+// Firmware effect message, which lifxlan doesn't implement
+// https://lan.developer.lifx.com/docs/tile-messages#settileeffect---packet-719
+const setTileEffect lifxlan.MessageType = 719
+
+// ErrNotMatrix is returned when showing frames or running firmware effects
+// on a device without a matrix of pixels
+var ErrNotMatrix = errors.New("device does not support matrix frames or effects")
+
+// MatrixEffectType is a firmware effect built into matrix devices
+type MatrixEffectType string
+
+const (
+	MatrixEffectOff   MatrixEffectType = "off"
+	MatrixEffectMorph MatrixEffectType = "morph"
+	MatrixEffectFlame MatrixEffectType = "flame"
+)
+
+// MatrixEffectTypes lists the supported firmware effects
+var MatrixEffectTypes = []MatrixEffectType{MatrixEffectFlame, MatrixEffectMorph, MatrixEffectOff}
+
+// ParseMatrixEffectType returns the firmware effect with the given name
+func ParseMatrixEffectType(s string) (MatrixEffectType, error) {
+	for _, t := range MatrixEffectTypes {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+
+	names := make([]string, len(MatrixEffectTypes))
+	for i, t := range MatrixEffectTypes {
+		names[i] = string(t)
+	}
+	return "", fmt.Errorf("unknown effect %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+func (t MatrixEffectType) lifx() uint8 {
+	switch t {
+	case MatrixEffectMorph:
+		return 2
+	case MatrixEffectFlame:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// MatrixEffect runs a firmware effect, animating every pixel of the device
+// until Duration has passed or, if it is zero, until the device is set
+// again
+type MatrixEffect struct {
+	Type     MatrixEffectType
+	Speed    time.Duration // duration of each animation cycle
+	Duration time.Duration
+	Palette  []Color // morph only
+}
+
+type rawSetTileEffectPayload struct {
+	_            [2]byte // reserved
+	InstanceID   uint32
+	Type         uint8
+	Speed        uint32   // milliseconds
+	Duration     uint64   // nanoseconds
+	_            [8]byte  // reserved
+	Parameters   [32]byte // unused by flame and morph
+	PaletteCount uint8
+	Palette      [16]lifxlan.Color
+}
+
+// Matrix is implemented by devices made up of a grid of pixels, such as
+// LIFX Tiles and Candles
+type Matrix interface {
+	Device
+	Size() (width, height int, err error)
+	Pixels() ([][]Color, error)
+	SetFrame(f *frame.Frame, brightness, kelvin uint16, transition time.Duration) error
+	MatrixEffect(*MatrixEffect) error
+}
+
+// matrix returns the bulb as a tile device. The caller must hold d.mu.
+func (d *LifxBulb) matrix() (tile.Device, error) {
+	td, ok := d.Device.(tile.Device)
+	if !ok {
+		return nil, ErrNotMatrix
+	}
+	return td, nil
+}
+
+// Size returns the width and height in pixels of the bulb's chain of
+// tiles, as they're arranged
+//
+// This implements Matrix
+func (d *LifxBulb) Size() (int, int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	td, err := d.matrix()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", d.label, err)
+	}
+	return td.Width(), td.Height(), nil
+}
+
+// Pixels returns the color of each of the bulb's pixels, row by row from
+// the top left. Positions between tiles are unlit.
+//
+// This implements Matrix
+func (d *LifxBulb) Pixels() ([][]Color, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	td, err := d.matrix()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.label, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	board, err := td.GetColors(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: get colors: %w", d.label, err)
+	}
+
+	// boards are indexed from the bottom left
+	pixels := make([][]Color, td.Height())
+	for row := range pixels {
+		pixels[row] = make([]Color, td.Width())
+		for x := range pixels[row] {
+			if c := board.GetColor(x, td.Height()-1-row); c != nil {
+				pixels[row][x] = Color{Hue: c.Hue, Saturation: c.Saturation, Brightness: c.Brightness, Kelvin: c.Kelvin}
+			}
+		}
+	}
+
+	return pixels, nil
+}
+
+// SetFrame transitions the bulb's pixels to the frame, scaled to the size
+// of the bulb's chain of tiles. Pixel brightness is scaled by brightness,
+// and kelvin applies to unsaturated pixels. Like Transition, the bulb is
+// powered on if needed and powered off if every pixel is unlit.
+//
+// This implements Matrix
+func (d *LifxBulb) SetFrame(f *frame.Frame, brightness, kelvin uint16, transition time.Duration) error {
+	err := d.setFrame(f, brightness, kelvin, transition)
+	if err != nil && !errors.Is(err, ErrNotMatrix) && d.rediscover() {
+		err = d.setFrame(f, brightness, kelvin, transition)
+	}
+	return err
+}
+
+func (d *LifxBulb) setFrame(f *frame.Frame, brightness, kelvin uint16, transition time.Duration) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	td, err := d.matrix()
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.label, err)
+	}
+
+	conn, err := d.Dial()
+	if err != nil {
+		return fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = d.echo(ctx, conn, nil)
+	if err != nil {
+		return fmt.Errorf("%s: echo device: %w", d.label, err)
+	}
+
+	scaled := f.Scale(td.Width(), td.Height())
+	board, dark := frameBoard(scaled, brightness, kelvin)
+	if dark {
+		err = d.SetLightPower(ctx, conn, lifxlan.PowerOff, transition, true)
+		if err != nil {
+			return fmt.Errorf("%s: set light power: %w", d.label, err)
+		}
+		return nil
+	}
+
+	power, err := d.GetPower(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("%s: get power: %w", d.label, err)
+	}
+
+	// If power is off, clear the pixels and turn on
+	if power == lifxlan.PowerOff {
+		off, _ := frameBoard(scaled, 0, kelvin)
+		err = td.SetColors(ctx, conn, off, time.Millisecond, true)
+		if err != nil {
+			return fmt.Errorf("%s: reset colors: %w", d.label, err)
+		}
+
+		err = d.SetPower(ctx, conn, lifxlan.PowerOn, true)
+		if err != nil {
+			return fmt.Errorf("%s: set power: %w", d.label, err)
+		}
+	}
+
+	err = td.SetColors(ctx, conn, board, transition, true)
+	if err != nil {
+		return fmt.Errorf("%s: set colors: %w", d.label, err)
+	}
+
+	return nil
+}
+
+// frameBoard converts the frame to a color board, which is indexed from
+// the bottom left, and reports whether every pixel is unlit
+func frameBoard(f *frame.Frame, brightness, kelvin uint16) (tile.ColorBoard, bool) {
+	dark := true
+	board := tile.MakeColorBoard(f.Width, f.Height)
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			px := f.At(x, y)
+			c := rgbColor(float64(px.R)/0xff, float64(px.G)/0xff, float64(px.B)/0xff)
+
+			// transparency dims the pixel
+			scale := float64(px.A) / 0xff * float64(brightness) / math.MaxUint16
+			c.Brightness = uint16(math.Round(float64(c.Brightness) * scale))
+			c.Kelvin = kelvin
+			dark = dark && c.Brightness == 0
+
+			board[x][f.Height-1-y] = &lifxlan.Color{
+				Hue:        c.Hue,
+				Saturation: c.Saturation,
+				Brightness: c.Brightness,
+				Kelvin:     c.Kelvin,
+			}
+		}
+	}
+	return board, dark
+}
+
+// MatrixEffect starts or stops one of the bulb's firmware effects, powering
+// the bulb on if needed
+//
+// This implements Matrix
+func (d *LifxBulb) MatrixEffect(effect *MatrixEffect) error {
+	err := d.matrixEffect(effect)
+	if err != nil && !errors.Is(err, ErrNotMatrix) && d.rediscover() {
+		err = d.matrixEffect(effect)
+	}
+	return err
+}
+
+func (d *LifxBulb) matrixEffect(effect *MatrixEffect) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, err := d.matrix()
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.label, err)
+	}
+
+	conn, err := d.Dial()
+	if err != nil {
+		return fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = d.echo(ctx, conn, nil)
+	if err != nil {
+		return fmt.Errorf("%s: echo device: %w", d.label, err)
+	}
+
+	// each new instance replaces whichever effect is running
+	payload := rawSetTileEffectPayload{
+		InstanceID: uint32(time.Now().UnixNano()),
+		Type:       effect.Type.lifx(),
+		Speed:      uint32(effect.Speed / time.Millisecond),
+		Duration:   uint64(effect.Duration),
+	}
+	for i, c := range effect.Palette {
+		if i >= len(payload.Palette) {
+			break
+		}
+		payload.Palette[i] = d.Device.SanitizeColor(lifxlan.Color{
+			Hue:        c.Hue,
+			Saturation: c.Saturation,
+			Brightness: c.Brightness,
+			Kelvin:     c.Kelvin,
+		})
+		payload.PaletteCount++
+	}
+
+	seq, err := d.Send(ctx, conn, lifxlan.FlagAckRequired, setTileEffect, &payload)
+	if err != nil {
+		return fmt.Errorf("%s: set tile effect: %w", d.label, err)
+	}
+	err = lifxlan.WaitForAcks(ctx, conn, d.Source(), seq)
+	if err != nil {
+		return fmt.Errorf("%s: set tile effect: %w", d.label, err)
+	}
+
+	if effect.Type == MatrixEffectOff {
+		return nil
+	}
+
+	power, err := d.GetPower(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("%s: get power: %w", d.label, err)
+	}
+	if power == lifxlan.PowerOff {
+		err = d.SetPower(ctx, conn, lifxlan.PowerOn, true)
+		if err != nil {
+			return fmt.Errorf("%s: set power: %w", d.label, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"go.yhsif.com/lifxlan"
+)
+
+// Extended multizone messages, which lifxlan doesn't implement
+// https://lan.developer.lifx.com/docs/changing-a-device#setextendedcolorzones---packet-510
+const (
+	setExtendedColorZones   lifxlan.MessageType = 510
+	getExtendedColorZones   lifxlan.MessageType = 511
+	stateExtendedColorZones lifxlan.MessageType = 512
+
+	// maxExtendedZones is the number of zones carried by each extended
+	// multizone message
+	maxExtendedZones = 82
+)
+
+// multizone application requests
+const (
+	multizoneNoApply uint8 = 0
+	multizoneApply   uint8 = 1
+)
+
+var (
+	// ErrNotMultizone is returned when setting zones on a device which
+	// has only a single zone
+	ErrNotMultizone = errors.New("device does not support multizone")
+
+	// ErrExtendedMultizone is returned for multizone devices whose
+	// firmware predates extended multizone messages
+	ErrExtendedMultizone = errors.New("device firmware does not support extended multizone, update the device's firmware")
+)
+
+type rawSetExtendedColorZonesPayload struct {
+	Duration    uint32 // milliseconds
+	Apply       uint8
+	Index       uint16
+	ColorsCount uint8
+	Colors      [maxExtendedZones]lifxlan.Color
+}
+
+type rawStateExtendedColorZonesPayload struct {
+	ZonesCount  uint16
+	ZoneIndex   uint16
+	ColorsCount uint8
+	Colors      [maxExtendedZones]lifxlan.Color
+}
+
+// Zoner is implemented by devices whose zones can be set individually,
+// such as LIFX Z strips and Beams
+type Zoner interface {
+	Device
+	Zones() ([]Color, error)
+	SetZones(*ZonePattern, time.Duration) error
+}
+
+// ZoneRange sets the zones from Start to End, inclusive, to Color
+type ZoneRange struct {
+	Start int
+	End   int
+	Color Color
+}
+
+// ZonePattern describes the colors of a multizone device's zones. Base
+// is applied to every zone, then the Gradient stops are spread evenly
+// along the device, and finally each of the Ranges is applied. Zones not
+// set by any of them keep their current color.
+type ZonePattern struct {
+	Base     *Color
+	Gradient []Color
+	Ranges   []ZoneRange
+}
+
+// Colors returns the color of each zone after applying the pattern to
+// zones of the given current colors
+func (p *ZonePattern) Colors(current []Color) []Color {
+	colors := make([]Color, len(current))
+	copy(colors, current)
+
+	if p.Base != nil {
+		for i := range colors {
+			colors[i] = *p.Base
+		}
+	}
+
+	switch {
+	case len(p.Gradient) == 1:
+		for i := range colors {
+			colors[i] = p.Gradient[0]
+		}
+	case len(p.Gradient) > 1 && len(colors) == 1:
+		colors[0] = p.Gradient[0]
+	case len(p.Gradient) > 1:
+		stops := len(p.Gradient) - 1
+		for i := range colors {
+			pos := float64(i) / float64(len(colors)-1) * float64(stops)
+			stop := int(math.Min(math.Floor(pos), float64(stops-1)))
+			colors[i] = interpolate(p.Gradient[stop], p.Gradient[stop+1], pos-float64(stop))
+		}
+	}
+
+	for _, r := range p.Ranges {
+		for i := r.Start; i <= r.End && i < len(colors); i++ {
+			if i >= 0 {
+				colors[i] = r.Color
+			}
+		}
+	}
+
+	return colors
+}
+
+// interpolate returns the color at fraction f of the way from a to b,
+// taking the shorter way around the hue circle
+func interpolate(a, b Color, f float64) Color {
+	linear := func(x, y uint16) uint16 {
+		return uint16(math.Round(float64(x) + (float64(y)-float64(x))*f))
+	}
+
+	hue := float64(b.Hue) - float64(a.Hue)
+	if hue > 0x8000 {
+		hue -= 0x10000
+	} else if hue < -0x8000 {
+		hue += 0x10000
+	}
+
+	return Color{
+		Hue:        uint16(int(math.Round(float64(a.Hue)+hue*f)) & 0xffff),
+		Saturation: linear(a.Saturation, b.Saturation),
+		Brightness: linear(a.Brightness, b.Brightness),
+		Kelvin:     linear(a.Kelvin, b.Kelvin),
+	}
+}
+
+// multizone checks that the bulb supports extended multizone messages.
+// The caller must hold d.mu.
+func (d *LifxBulb) multizone() error {
+	product := d.Device.HardwareVersion().Parse()
+	if product == nil {
+		return ErrNotMultizone
+	}
+
+	features := product.FeaturesAt(*d.Device.Firmware())
+	if !features.Multizone.Get() {
+		return ErrNotMultizone
+	}
+	if !features.ExtendedMultizone.Get() {
+		return ErrExtendedMultizone
+	}
+	return nil
+}
+
+// getZones queries the color of each of the bulb's zones. The caller must
+// hold d.mu.
+func (d *LifxBulb) getZones(ctx context.Context, conn net.Conn) ([]Color, error) {
+	seq, err := d.Send(ctx, conn, 0, getExtendedColorZones, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// strips with more zones than fit in one message respond with several
+	var zones []Color
+	received := 0
+	for zones == nil || received < len(zones) {
+		resp, err := lifxlan.ReadNextResponse(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Source != d.Source() || resp.Sequence != seq || resp.Message != stateExtendedColorZones {
+			continue
+		}
+
+		var raw rawStateExtendedColorZonesPayload
+		err = binary.Read(bytes.NewReader(resp.Payload), binary.LittleEndian, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("decode zones: %w", err)
+		}
+		if zones == nil {
+			zones = make([]Color, raw.ZonesCount)
+		}
+
+		for i := 0; i < int(raw.ColorsCount) && i < maxExtendedZones; i++ {
+			index := int(raw.ZoneIndex) + i
+			if index >= len(zones) {
+				break
+			}
+			c := raw.Colors[i]
+			zones[index] = Color{Hue: c.Hue, Saturation: c.Saturation, Brightness: c.Brightness, Kelvin: c.Kelvin}
+			received++
+		}
+		if raw.ColorsCount == 0 {
+			break
+		}
+	}
+
+	return zones, nil
+}
+
+// setZones sets the color of each of the bulb's zones over the transition
+// duration. The caller must hold d.mu.
+func (d *LifxBulb) setZones(ctx context.Context, conn net.Conn, colors []Color, transition time.Duration) error {
+	var sequences []uint8
+	for index := 0; index < len(colors); index += maxExtendedZones {
+		end := index + maxExtendedZones
+		if end > len(colors) {
+			end = len(colors)
+		}
+
+		// earlier messages are buffered so that every zone changes at once
+		payload := rawSetExtendedColorZonesPayload{
+			Duration:    uint32(transition / time.Millisecond),
+			Apply:       multizoneNoApply,
+			Index:       uint16(index),
+			ColorsCount: uint8(end - index),
+		}
+		if end == len(colors) {
+			payload.Apply = multizoneApply
+		}
+		for i, c := range colors[index:end] {
+			payload.Colors[i] = d.Device.SanitizeColor(lifxlan.Color{
+				Hue:        c.Hue,
+				Saturation: c.Saturation,
+				Brightness: c.Brightness,
+				Kelvin:     c.Kelvin,
+			})
+		}
+
+		seq, err := d.Send(ctx, conn, lifxlan.FlagAckRequired, setExtendedColorZones, &payload)
+		if err != nil {
+			return err
+		}
+		sequences = append(sequences, seq)
+	}
+
+	return lifxlan.WaitForAcks(ctx, conn, d.Source(), sequences...)
+}
+
+// Zones returns the current color of each of the bulb's zones
+//
+// This implements Zoner
+func (d *LifxBulb) Zones() ([]Color, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	err := d.multizone()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.label, err)
+	}
+
+	conn, err := d.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	zones, err := d.getZones(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: get zones: %w", d.label, err)
+	}
+
+	return zones, nil
+}
+
+// SetZones transitions the bulb's zones to the colors described by the
+// pattern. Like Transition, the bulb is powered on if needed and powered
+// off if every zone's brightness is zero.
+//
+// This implements Zoner
+func (d *LifxBulb) SetZones(pattern *ZonePattern, transition time.Duration) error {
+	err := d.setZonePattern(pattern, transition)
+	if err != nil && !errors.Is(err, ErrNotMultizone) && !errors.Is(err, ErrExtendedMultizone) && d.rediscover() {
+		err = d.setZonePattern(pattern, transition)
+	}
+	return err
+}
+
+func (d *LifxBulb) setZonePattern(pattern *ZonePattern, transition time.Duration) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	err := d.multizone()
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.label, err)
+	}
+
+	conn, err := d.Dial()
+	if err != nil {
+		return fmt.Errorf("%s: dial: %w", d.label, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = d.echo(ctx, conn, nil)
+	if err != nil {
+		return fmt.Errorf("%s: echo device: %w", d.label, err)
+	}
+
+	current, err := d.getZones(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("%s: get zones: %w", d.label, err)
+	}
+	colors := pattern.Colors(current)
+
+	dark := true
+	for _, c := range colors {
+		dark = dark && c.Brightness == 0
+	}
+	if dark {
+		err = d.SetLightPower(ctx, conn, lifxlan.PowerOff, transition, true)
+		if err != nil {
+			return fmt.Errorf("%s: set light power: %w", d.label, err)
+		}
+		return nil
+	}
+
+	power, err := d.GetPower(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("%s: get power: %w", d.label, err)
+	}
+
+	// If power is off, reset zone brightness to 0 and turn on
+	if power == lifxlan.PowerOff {
+		off := make([]Color, len(colors))
+		for i, c := range colors {
+			off[i] = c
+			off[i].Brightness = 0
+		}
+
+		err = d.setZones(ctx, conn, off, time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("%s: reset zones: %w", d.label, err)
+		}
+
+		err = d.SetPower(ctx, conn, lifxlan.PowerOn, true)
+		if err != nil {
+			return fmt.Errorf("%s: set power: %w", d.label, err)
+		}
+	}
+
+	err = d.setZones(ctx, conn, colors, transition)
+	if err != nil {
+		return fmt.Errorf("%s: set zones: %w", d.label, err)
+	}
+
+	return nil
+}
+
+// ColorsJSON formats colors, such as those of zones, as a JSON array in
+// the same units as the status endpoint
+func ColorsJSON(colors []Color) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, c := range colors {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(
+			&b,
+			`{"hue": %.2f, "saturation": %.2f, "brightness": %.2f, "kelvin": %d}`,
+			float64(c.Hue)*360.0/0x10000,
+			float64(c.Saturation)/math.MaxUint16*100,
+			float64(c.Brightness)/math.MaxUint16*100,
+			c.Kelvin,
+		)
+	}
+	b.WriteString("]")
+	return b.String()
+}
@@ -0,0 +1,211 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+// brightnesses returns colors with the given brightnesses
+func brightnesses(b ...uint16) []Color {
+	colors := make([]Color, len(b))
+	for i := range b {
+		colors[i] = Color{Brightness: b[i], Kelvin: 3500}
+	}
+	return colors
+}
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Color
+		f    float64
+		want Color
+	}{
+		{
+			name: "start",
+			a:    Color{Hue: 0x1000, Saturation: 100, Brightness: 200, Kelvin: 2500},
+			b:    Color{Hue: 0x3000, Saturation: 300, Brightness: 400, Kelvin: 6500},
+			f:    0,
+			want: Color{Hue: 0x1000, Saturation: 100, Brightness: 200, Kelvin: 2500},
+		},
+		{
+			name: "end",
+			a:    Color{Hue: 0x1000, Saturation: 100, Brightness: 200, Kelvin: 2500},
+			b:    Color{Hue: 0x3000, Saturation: 300, Brightness: 400, Kelvin: 6500},
+			f:    1,
+			want: Color{Hue: 0x3000, Saturation: 300, Brightness: 400, Kelvin: 6500},
+		},
+		{
+			name: "midpoint",
+			a:    Color{Hue: 0x1000, Saturation: 100, Brightness: 200, Kelvin: 2500},
+			b:    Color{Hue: 0x3000, Saturation: 300, Brightness: 400, Kelvin: 6500},
+			f:    0.5,
+			want: Color{Hue: 0x2000, Saturation: 200, Brightness: 300, Kelvin: 4500},
+		},
+		{
+			name: "decreasing",
+			a:    Color{Brightness: 400, Kelvin: 6500},
+			b:    Color{Brightness: 0, Kelvin: 2500},
+			f:    0.25,
+			want: Color{Brightness: 300, Kelvin: 5500},
+		},
+		{
+			name: "hue wraps upward",
+			a:    Color{Hue: 0xf000},
+			b:    Color{Hue: 0x1000},
+			f:    0.5,
+			want: Color{Hue: 0},
+		},
+		{
+			name: "hue wraps downward",
+			a:    Color{Hue: 0x1000},
+			b:    Color{Hue: 0xf000},
+			f:    0.25,
+			want: Color{Hue: 0x0800},
+		},
+		{
+			name: "hue wraps past zero",
+			a:    Color{Hue: 0x1000},
+			b:    Color{Hue: 0xe000},
+			f:    0.75,
+			want: Color{Hue: 0xec00},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := interpolate(test.a, test.b, test.f)
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestZonePatternColors(t *testing.T) {
+	red := Color{Hue: 0, Saturation: 0xffff, Brightness: 0xffff, Kelvin: 3500}
+	blue := Color{Hue: 0xaaaa, Saturation: 0xffff, Brightness: 0xffff, Kelvin: 3500}
+
+	tests := []struct {
+		name    string
+		pattern ZonePattern
+		current []Color
+		want    []Color
+	}{
+		{
+			name:    "empty pattern keeps current",
+			pattern: ZonePattern{},
+			current: brightnesses(1, 2, 3),
+			want:    brightnesses(1, 2, 3),
+		},
+		{
+			name:    "base replaces current",
+			pattern: ZonePattern{Base: &blue},
+			current: brightnesses(1, 2, 3),
+			want:    []Color{blue, blue, blue},
+		},
+		{
+			name:    "single color gradient",
+			pattern: ZonePattern{Gradient: []Color{red}},
+			current: brightnesses(1, 2, 3),
+			want:    []Color{red, red, red},
+		},
+		{
+			name:    "two stops",
+			pattern: ZonePattern{Gradient: brightnesses(0, 400)},
+			current: make([]Color, 5),
+			want:    brightnesses(0, 100, 200, 300, 400),
+		},
+		{
+			name:    "three stops",
+			pattern: ZonePattern{Gradient: brightnesses(0, 400, 0)},
+			current: make([]Color, 5),
+			want:    brightnesses(0, 200, 400, 200, 0),
+		},
+		{
+			name:    "more stops than zones",
+			pattern: ZonePattern{Gradient: brightnesses(0, 100, 200, 300, 400)},
+			current: make([]Color, 3),
+			want:    brightnesses(0, 200, 400),
+		},
+		{
+			name:    "gradient on one zone",
+			pattern: ZonePattern{Gradient: brightnesses(100, 400)},
+			current: make([]Color, 1),
+			want:    brightnesses(100),
+		},
+		{
+			name:    "gradient wraps hue",
+			pattern: ZonePattern{Gradient: []Color{{Hue: 0xf000}, {Hue: 0x1000}}},
+			current: make([]Color, 3),
+			want:    []Color{{Hue: 0xf000}, {Hue: 0}, {Hue: 0x1000}},
+		},
+		{
+			name: "range over gradient",
+			pattern: ZonePattern{
+				Gradient: brightnesses(0, 400),
+				Ranges:   []ZoneRange{{Start: 1, End: 2, Color: red}},
+			},
+			current: make([]Color, 5),
+			want:    append(append(brightnesses(0), red, red), brightnesses(300, 400)...),
+		},
+		{
+			name: "range over current",
+			pattern: ZonePattern{
+				Ranges: []ZoneRange{{Start: 2, End: 2, Color: red}},
+			},
+			current: brightnesses(1, 2, 3),
+			want:    append(brightnesses(1, 2), red),
+		},
+		{
+			name: "later ranges win",
+			pattern: ZonePattern{
+				Ranges: []ZoneRange{
+					{Start: 0, End: 2, Color: red},
+					{Start: 1, End: 1, Color: blue},
+				},
+			},
+			current: brightnesses(1, 2, 3),
+			want:    []Color{red, blue, red},
+		},
+		{
+			name: "range clipped at end",
+			pattern: ZonePattern{
+				Ranges: []ZoneRange{{Start: 1, End: 10, Color: red}},
+			},
+			current: brightnesses(1, 2, 3),
+			want:    append(brightnesses(1), red, red),
+		},
+		{
+			name: "range clipped at start",
+			pattern: ZonePattern{
+				Ranges: []ZoneRange{{Start: -2, End: 0, Color: red}},
+			},
+			current: brightnesses(1, 2, 3),
+			want:    append([]Color{red}, brightnesses(2, 3)...),
+		},
+		{
+			name: "range past end",
+			pattern: ZonePattern{
+				Ranges: []ZoneRange{{Start: 3, End: 5, Color: red}},
+			},
+			current: brightnesses(1, 2, 3),
+			want:    brightnesses(1, 2, 3),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := make([]Color, len(test.current))
+			copy(current, test.current)
+
+			got := test.pattern.Colors(current)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			if !reflect.DeepEqual(current, test.current) {
+				t.Errorf("current modified: got %+v, want %+v", current, test.current)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrMissingBrightness is returned by ParseColorParams when a request
+// doesn't include a brightness parameter
+var ErrMissingBrightness = errors.New("brightness parameter is required")
+
+// ParamError indicates that a request parameter could not be parsed
+type ParamError struct {
+	Param string
+	Value string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("parse %s param %q: %s", e.Param, e.Value, e.Err)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// ParseColorParams parses the hue, saturation, brightness, kelvin, and
+// transition parameters used by device power handlers. Values outside of
+// their valid ranges are clamped. Transitions without units are treated
+// as milliseconds.
+func ParseColorParams(r *http.Request) (*Color, time.Duration, error) {
+	r.ParseForm()
+
+	parse := func(param string, max float64) (float64, bool, error) {
+		if _, ok := r.Form[param]; !ok {
+			return 0, false, nil
+		}
+
+		value := r.FormValue(param)
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, true, &ParamError{Param: param, Value: value, Err: err}
+		}
+
+		return math.Max(0, math.Min(p, max)), true, nil
+	}
+
+	color := &Color{}
+
+	hue, _, err := parse("hue", 360)
+	if err != nil {
+		return nil, 0, err
+	}
+	color.Hue = uint16(math.Floor((hue / 360.0) * float64(math.MaxUint16)))
+
+	saturation, _, err := parse("saturation", 100)
+	if err != nil {
+		return nil, 0, err
+	}
+	color.Saturation = uint16(math.Floor((saturation / 100.0) * float64(math.MaxUint16)))
+
+	brightness, ok, err := parse("brightness", 100)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, ErrMissingBrightness
+	}
+	color.Brightness = uint16(math.Floor((brightness / 100.0) * float64(math.MaxUint16)))
+
+	if _, ok := r.Form["kelvin"]; ok {
+		param := r.FormValue("kelvin")
+		p, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, 0, &ParamError{Param: "kelvin", Value: param, Err: err}
+		}
+
+		if p < 1500 {
+			p = 1500
+		} else if p > 9000 {
+			p = 9000
+		}
+
+		color.Kelvin = uint16(p)
+	}
+
+	transition := defaultPowerTransition
+	if _, ok := r.Form["transition"]; ok {
+		param := r.FormValue("transition")
+		_, err := strconv.Atoi(param)
+		if err == nil && param != "" {
+			param = param + "ms"
+		}
+
+		parsed, err := time.ParseDuration(param)
+		if err != nil {
+			return nil, 0, &ParamError{Param: "transition", Value: param, Err: err}
+		}
+		transition = parsed
+	}
+
+	return color, transition, nil
+}
+
+// WriteParamError responds to a request whose parameters could not be
+// parsed by ParseColorParams
+func WriteParamError(w http.ResponseWriter, label string, err error) {
+	var paramErr *ParamError
+	if errors.As(err, &paramErr) {
+		log.Printf("ERR: %s: %s", label, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error": "unable to parse %s parameter"}`, paramErr.Param)
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `{"error": %q}`, err.Error())
+}
@@ -7,6 +7,7 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -69,22 +70,45 @@ func (s *S31) Transition(color *Color, transition time.Duration) error {
 	return nil
 }
 
-func (s *S31) StatusHandler(w http.ResponseWriter, r *http.Request) {
+// state queries the current power state of the device
+func (s *S31) state() (*TasmotaPowerState, error) {
 	query := fmt.Sprintf("http://%s/cm?cmnd=State", s.Address)
 	res, err := http.Get(query)
 	if err != nil {
-		log.Printf("ERR: %s: query state: %w", s.label, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "unable to connect to device"}`))
-		return
+		return nil, fmt.Errorf("%s: query state: %w", s.label, err)
 	}
+	defer res.Body.Close()
 
 	var state TasmotaPowerState
 	err = json.NewDecoder(res.Body).Decode(&state)
 	if err != nil {
-		log.Printf("ERR: %s: decode state: %w", s.label, err)
+		return nil, fmt.Errorf("%s: decode state: %w", s.label, err)
+	}
+
+	return &state, nil
+}
+
+// Status returns full brightness if the device is powered on and zero
+// brightness otherwise
+func (s *S31) Status() (*Color, error) {
+	state, err := s.state()
+	if err != nil {
+		return nil, err
+	}
+
+	color := &Color{}
+	if strings.EqualFold(state.Power, "on") {
+		color.Brightness = math.MaxUint16
+	}
+	return color, nil
+}
+
+func (s *S31) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := s.state()
+	if err != nil {
+		log.Printf("ERR: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "unable to decode device state"}`))
+		w.Write([]byte(`{"error": "unable to query device state"}`))
 		return
 	}
 
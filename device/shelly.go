@@ -179,22 +179,49 @@ func (s *Shelly) Transition(color *Color, transition time.Duration) error {
 	return errors.Join(errs...)
 }
 
-func (s *Shelly) StatusHandler(w http.ResponseWriter, r *http.Request) {
-	query := fmt.Sprintf("http://%s/rpc/Switch.GetStatus?id=0", s.Address)
+// switchStatus queries the status of the switch at the given index
+func (s *Shelly) switchStatus(index int) (*ShellySwitchStatusResponse, error) {
+	query := fmt.Sprintf("http://%s/rpc/Switch.GetStatus?id=%d", s.Address, index)
 	res, err := http.Get(query)
 	if err != nil {
-		log.Printf("ERR: %s: query status: %w", s.label, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "unable to connect to device"}`))
-		return
+		return nil, fmt.Errorf("%s: query status: %w", s.label, err)
 	}
+	defer res.Body.Close()
 
 	var status ShellySwitchStatusResponse
 	err = json.NewDecoder(res.Body).Decode(&status)
 	if err != nil {
-		log.Printf("ERR: %s: decode status: %w", s.label, err)
+		return nil, fmt.Errorf("%s: decode status: %w", s.label, err)
+	}
+
+	return &status, nil
+}
+
+// Status returns full brightness if any controlled output is on and zero
+// brightness otherwise
+func (s *Shelly) Status() (*Color, error) {
+	color := &Color{}
+	for _, index := range s.indexes {
+		status, err := s.switchStatus(index)
+		if err != nil {
+			return nil, err
+		}
+
+		if status.Output {
+			color.Brightness = math.MaxUint16
+			break
+		}
+	}
+
+	return color, nil
+}
+
+func (s *Shelly) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := s.switchStatus(0)
+	if err != nil {
+		log.Printf("ERR: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "unable to decode device status"}`))
+		w.Write([]byte(`{"error": "unable to query device status"}`))
 		return
 	}
 
@@ -0,0 +1,69 @@
+package lamplighter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ElevationSchedule struct {
+	Location  Location      `json:"location"`
+	Elevation float64       `json:"elevation"` // degrees above the horizon
+	Rising    bool          `json:"rising"`
+	Offset    time.Duration `json:"offset"`
+	Fallback  *Clock        `json:"fallback,omitempty"`
+}
+
+// Next returns the next time at which the sun crosses the
+// ElevationSchedule's elevation, in the morning if Rising is true and in
+// the evening otherwise. Days on which the sun does not cross the
+// elevation are skipped unless a fallback is set.
+//
+// This implements robfig/cron.Schedule
+func (s ElevationSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return next
+}
+
+func (s ElevationSchedule) IsFallback(now time.Time) bool {
+	_, isFallback := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return isFallback
+}
+
+func (s ElevationSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
+	rising, setting, ok := newSolarDay(s.Location.Longitude, year, month, day).crossing(s.Location.Latitude, s.Elevation)
+	if s.Rising {
+		return rising, ok
+	}
+	return setting, ok
+}
+
+// parseElevation parses an angle in degrees, such as "-6deg", "-6°" or
+// "-6", and verifies that it falls within the range of solar elevations
+func parseElevation(s string) (float64, error) {
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(s, "deg"), "°")
+	elevation, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse elevation %q: %w", s, err)
+	}
+
+	if elevation < -90 || elevation > 90 {
+		return 0, fmt.Errorf("elevation %q out of range [-90, 90]", s)
+	}
+
+	return elevation, nil
+}
+
+// parseDirection parses whether an elevation schedule fires while the
+// sun is rising or setting
+func parseDirection(s string) (bool, error) {
+	switch s {
+	case "rising":
+		return true, nil
+	case "setting":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown sun direction %q, expected rising or setting", s)
+	}
+}
@@ -0,0 +1,197 @@
+// Package frame reads the images shown on LIFX matrix devices, such as
+// Tiles and Candles, from PNG files or JSON grids of hex colors.
+//
+// A JSON frame is an array of rows from top to bottom, each an array of
+// "#rrggbb" colors from left to right. Empty strings are unlit pixels.
+package frame
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Frame is a grid of pixel colors. Pixels are indexed from the top left
+// corner and transparent pixels are unlit.
+type Frame struct {
+	Width  int
+	Height int
+	Pixels []color.NRGBA // row by row from the top
+}
+
+// New returns an unlit frame of the given size
+func New(width, height int) *Frame {
+	return &Frame{
+		Width:  width,
+		Height: height,
+		Pixels: make([]color.NRGBA, width*height),
+	}
+}
+
+// Open reads the frame from the given PNG or JSON file, depending on
+// its extension
+func Open(filename string) (*Frame, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read frame file: %w", err)
+	}
+	defer f.Close()
+
+	var frame *Frame
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".png":
+		frame, err = DecodePNG(f)
+	case ".json":
+		frame, err = DecodeJSON(f)
+	default:
+		return nil, fmt.Errorf("%s: unsupported frame file type %q, expected .png or .json", filename, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return frame, nil
+}
+
+// DecodePNG reads a frame from a PNG image
+func DecodePNG(r io.Reader) (*Frame, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode png: %w", err)
+	}
+
+	bounds := img.Bounds()
+	frame := New(bounds.Dx(), bounds.Dy())
+	for y := 0; y < frame.Height; y++ {
+		for x := 0; x < frame.Width; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			frame.Set(x, y, color.NRGBAModel.Convert(c).(color.NRGBA))
+		}
+	}
+
+	return frame, nil
+}
+
+// DecodeJSON reads a frame from a JSON grid of hex colors
+func DecodeJSON(r io.Reader) (*Frame, error) {
+	var rows [][]string
+	err := json.NewDecoder(r).Decode(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+	return FromRows(rows)
+}
+
+// FromRows builds a frame from rows of hex colors. Every row must be the
+// same length.
+func FromRows(rows [][]string) (*Frame, error) {
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return nil, fmt.Errorf("frame has no pixels")
+	}
+
+	frame := New(len(rows[0]), len(rows))
+	for y, row := range rows {
+		if len(row) != frame.Width {
+			return nil, fmt.Errorf("row %d: has %d pixels, expected %d", y, len(row), frame.Width)
+		}
+		for x, hex := range row {
+			c, err := parseHex(hex)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: pixel %d: %w", y, x, err)
+			}
+			frame.Set(x, y, c)
+		}
+	}
+
+	return frame, nil
+}
+
+func parseHex(s string) (color.NRGBA, error) {
+	if s == "" {
+		return color.NRGBA{}, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("expected a color in #rrggbb format, got %q", s)
+	}
+
+	return color.NRGBA{
+		R: uint8(rgb >> 16),
+		G: uint8(rgb >> 8),
+		B: uint8(rgb),
+		A: 0xff,
+	}, nil
+}
+
+// At returns the color of the pixel at x, y. Pixels outside the frame are
+// unlit.
+func (f *Frame) At(x, y int) color.NRGBA {
+	if x < 0 || x >= f.Width || y < 0 || y >= f.Height {
+		return color.NRGBA{}
+	}
+	return f.Pixels[y*f.Width+x]
+}
+
+// Set sets the color of the pixel at x, y
+func (f *Frame) Set(x, y int, c color.NRGBA) {
+	if x < 0 || x >= f.Width || y < 0 || y >= f.Height {
+		return
+	}
+	f.Pixels[y*f.Width+x] = c
+}
+
+// Scale returns the frame resized to the given dimensions. When shrinking,
+// each pixel is the average of the pixels it covers; when growing, pixels
+// are repeated.
+func (f *Frame) Scale(width, height int) *Frame {
+	scaled := New(width, height)
+	for y := 0; y < height; y++ {
+		top, bottom := span(y, height, f.Height)
+		for x := 0; x < width; x++ {
+			left, right := span(x, width, f.Width)
+
+			// colors are averaged weighted by opacity so that unlit
+			// pixels darken rather than discolor their neighbours
+			var r, g, b, a, n int
+			for sy := top; sy < bottom; sy++ {
+				for sx := left; sx < right; sx++ {
+					c := f.At(sx, sy)
+					r += int(c.R) * int(c.A)
+					g += int(c.G) * int(c.A)
+					b += int(c.B) * int(c.A)
+					a += int(c.A)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			scaled.Set(x, y, color.NRGBA{
+				R: uint8(r / a),
+				G: uint8(g / a),
+				B: uint8(b / a),
+				A: uint8(a / n),
+			})
+		}
+	}
+
+	return scaled
+}
+
+// span returns the range of source pixels covered by pixel i of a row of
+// n pixels scaled from size pixels. Every pixel covers at least one.
+func span(i, n, size int) (int, int) {
+	start := i * size / n
+	end := (i + 1) * size / n
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
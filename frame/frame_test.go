@@ -0,0 +1,135 @@
+package frame
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+var (
+	unlit = color.NRGBA{}
+	red   = color.NRGBA{R: 0xff, A: 0xff}
+	green = color.NRGBA{G: 0xff, A: 0xff}
+	blue  = color.NRGBA{B: 0xff, A: 0xff}
+	white = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// grid builds a frame from rows of pixels
+func grid(rows ...[]color.NRGBA) *Frame {
+	f := New(len(rows[0]), len(rows))
+	for y, row := range rows {
+		for x, c := range row {
+			f.Set(x, y, c)
+		}
+	}
+	return f
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name          string
+		frame         *Frame
+		width, height int
+		want          *Frame
+	}{
+		{
+			name:   "same size",
+			frame:  grid([]color.NRGBA{red, green}, []color.NRGBA{blue, white}),
+			width:  2,
+			height: 2,
+			want:   grid([]color.NRGBA{red, green}, []color.NRGBA{blue, white}),
+		},
+		{
+			name:   "grow repeats pixels",
+			frame:  grid([]color.NRGBA{red, green}),
+			width:  4,
+			height: 2,
+			want: grid(
+				[]color.NRGBA{red, red, green, green},
+				[]color.NRGBA{red, red, green, green},
+			),
+		},
+		{
+			name:   "uneven growth",
+			frame:  grid([]color.NRGBA{red, green}),
+			width:  3,
+			height: 1,
+			want:   grid([]color.NRGBA{red, red, green}),
+		},
+		{
+			name:   "shrink averages pixels",
+			frame:  grid([]color.NRGBA{red, green}, []color.NRGBA{blue, white}),
+			width:  1,
+			height: 1,
+			want:   grid([]color.NRGBA{{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}}),
+		},
+		{
+			name:   "shrink one axis",
+			frame:  grid([]color.NRGBA{red, blue, green, green}),
+			width:  2,
+			height: 1,
+			want:   grid([]color.NRGBA{{R: 0x7f, B: 0x7f, A: 0xff}, green}),
+		},
+		{
+			name:   "unlit pixels darken",
+			frame:  grid([]color.NRGBA{red, unlit}),
+			width:  1,
+			height: 1,
+			want:   grid([]color.NRGBA{{R: 0xff, A: 0x7f}}),
+		},
+		{
+			name:   "opacity weights colors",
+			frame:  grid([]color.NRGBA{red, {B: 0xff, A: 0x55}}),
+			width:  1,
+			height: 1,
+			want:   grid([]color.NRGBA{{R: 0xbf, B: 0x3f, A: 0xaa}}),
+		},
+		{
+			name:   "unlit stays unlit",
+			frame:  grid([]color.NRGBA{unlit, unlit}, []color.NRGBA{unlit, unlit}),
+			width:  1,
+			height: 1,
+			want:   grid([]color.NRGBA{unlit}),
+		},
+		{
+			name:   "shrink and grow",
+			frame:  grid([]color.NRGBA{red, green, blue, white}),
+			width:  2,
+			height: 2,
+			want: grid(
+				[]color.NRGBA{{R: 0x7f, G: 0x7f, A: 0xff}, {R: 0x7f, G: 0x7f, B: 0xff, A: 0xff}},
+				[]color.NRGBA{{R: 0x7f, G: 0x7f, A: 0xff}, {R: 0x7f, G: 0x7f, B: 0xff, A: 0xff}},
+			),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.frame.Scale(test.width, test.height)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		i, n, size int
+		start, end int
+	}{
+		{i: 0, n: 2, size: 4, start: 0, end: 2},
+		{i: 1, n: 2, size: 4, start: 2, end: 4},
+		{i: 0, n: 3, size: 2, start: 0, end: 1},
+		{i: 1, n: 3, size: 2, start: 0, end: 1},
+		{i: 2, n: 3, size: 2, start: 1, end: 2},
+		{i: 2, n: 3, size: 8, start: 5, end: 8},
+	}
+
+	for _, test := range tests {
+		start, end := span(test.i, test.n, test.size)
+		if start != test.start || end != test.end {
+			t.Errorf("span(%d, %d, %d): got %d-%d, want %d-%d", test.i, test.n, test.size, start, end, test.start, test.end)
+		}
+	}
+}
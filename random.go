@@ -0,0 +1,138 @@
+package lamplighter
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// JitterSchedule shifts each activation time of a schedule by a
+// pseudo-random amount between -Window and +Window. The shift is derived
+// from Seed and the unshifted time, so the same seed always produces the
+// same activation times.
+type JitterSchedule struct {
+	Schedule cron.Schedule `json:"schedule"`
+	Window   time.Duration `json:"window"`
+	Seed     int64         `json:"seed"`
+}
+
+// Next returns the first shifted activation time of the underlying
+// schedule after now
+//
+// This implements robfig/cron.Schedule
+func (s JitterSchedule) Next(now time.Time) time.Time {
+	next, _ := s.next(now)
+	return next
+}
+
+// IsFallback reports whether the next activation time after now is the
+// underlying schedule's fallback time
+//
+// This implements FallbackSchedule
+func (s JitterSchedule) IsFallback(now time.Time) bool {
+	return wrappedFallback(s.Schedule, s.next, now)
+}
+
+// next returns the next shifted activation time and the time from which
+// the underlying schedule produced it
+func (s JitterSchedule) next(now time.Time) (next, from time.Time) {
+	// events up to one window after now may be shifted to before now
+	from = now.Add(-s.Window)
+	for i := 0; i < maxSearchDays; i++ {
+		t := s.Schedule.Next(from)
+		if t.IsZero() {
+			break
+		}
+
+		next = t.Add(s.shift(t))
+		if next.After(now) {
+			return next, from
+		}
+		from = t
+	}
+
+	return time.Time{}, time.Time{}
+}
+
+// shift returns the offset applied to the activation time t, rounded to
+// the second
+func (s JitterSchedule) shift(t time.Time) time.Duration {
+	window := int64(s.Window / time.Second)
+	if window <= 0 {
+		return 0
+	}
+
+	rng := seededRand(s.Seed, t.Unix())
+	return time.Duration(rng.Int63n(2*window+1)-window) * time.Second
+}
+
+// RandomSchedule fires at pseudo-random times between Start and End each
+// day. Count times are generated per day and sorted; the schedule fires at
+// the one at position Index. Schedules sharing a Seed and Count produce
+// the same times, so pairing even and odd indexes yields alternating
+// events which never overlap. If End is not after Start, the window ends
+// on the following day.
+type RandomSchedule struct {
+	Start Clock `json:"start"`
+	End   Clock `json:"end"`
+	Seed  int64 `json:"seed"`
+	Count int   `json:"count"`
+	Index int   `json:"index"`
+}
+
+// Next returns the first activation time after now
+//
+// This implements robfig/cron.Schedule
+func (s RandomSchedule) Next(now time.Time) time.Time {
+	if s.Index < 0 || s.Index >= s.Count {
+		return time.Time{}
+	}
+
+	// start with yesterday in case its window extends past midnight
+	date := now.AddDate(0, 0, -1)
+	for i := 0; i < 3; i++ {
+		t := s.times(date)[s.Index]
+		if t.After(now) {
+			return t
+		}
+		date = date.AddDate(0, 0, 1)
+	}
+
+	return time.Time{}
+}
+
+// times returns the sorted activation times for the window starting on
+// the given date
+func (s RandomSchedule) times(date time.Time) []time.Time {
+	start := s.Start.On(date)
+	end := s.End.On(date)
+	if !end.After(start) {
+		end = s.End.On(date.AddDate(0, 0, 1))
+	}
+	window := int64(end.Sub(start) / time.Second)
+
+	year, month, day := date.Date()
+	rng := seededRand(s.Seed, int64(year)*10000+int64(month)*100+int64(day))
+
+	times := make([]time.Time, s.Count)
+	for i := range times {
+		times[i] = start.Add(time.Duration(rng.Int63n(window)) * time.Second)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	return times
+}
+
+// seededRand returns a random number generator seeded by a hash of the
+// given values
+func seededRand(values ...int64) *rand.Rand {
+	hash := fnv.New64a()
+	for _, v := range values {
+		binary.Write(hash, binary.LittleEndian, v)
+	}
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
@@ -0,0 +1,202 @@
+package lamplighter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// firings returns the first n activation times of the schedule after now
+func firings(schedule interface{ Next(time.Time) time.Time }, now time.Time, n int) []time.Time {
+	var times []time.Time
+	for t := schedule.Next(now); !t.IsZero() && len(times) < n; t = schedule.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+func TestJitterSchedule(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		spec   string
+		window time.Duration
+	}{
+		{name: "hourly", spec: "CRON_TZ=UTC 0 * * * *", window: 10 * time.Minute},
+		{name: "daily", spec: "CRON_TZ=UTC 0 7 * * *", window: 30 * time.Minute},
+		{name: "window wider than interval", spec: "CRON_TZ=UTC */10 * * * *", window: 20 * time.Minute},
+		{name: "no window", spec: "CRON_TZ=UTC 0 7 * * *"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			underlying := mustParse(t, test.spec)
+			schedule := JitterSchedule{Schedule: underlying, Window: test.window, Seed: 1234}
+
+			got := firings(schedule, now, 50)
+			if len(got) != 50 {
+				t.Fatalf("got %d firings, want 50", len(got))
+			}
+
+			again := firings(JitterSchedule{Schedule: underlying, Window: test.window, Seed: 1234}, now, 50)
+			if !reflect.DeepEqual(got, again) {
+				t.Errorf("same seed produced different times:\n%v\n%v", got, again)
+			}
+
+			shifted := false
+			for i, fired := range got {
+				if !fired.After(now) {
+					t.Errorf("firing %d at %s, expected after %s", i, fired, now)
+				}
+				if i > 0 && !fired.After(got[i-1]) {
+					t.Errorf("firing %d at %s, expected after %s", i, fired, got[i-1])
+				}
+
+				// each firing is within the window of an unshifted time
+				nearest := underlying.Next(fired.Add(-test.window - time.Nanosecond))
+				if nearest.Sub(fired) > test.window {
+					t.Errorf("firing %d at %s, expected within %s of an activation time", i, fired, test.window)
+				}
+				if fired.Truncate(time.Minute) != fired {
+					shifted = true
+				}
+			}
+			if test.window == 0 && shifted {
+				t.Errorf("times shifted without a window: %v", got)
+			}
+			if test.window > 0 && !shifted {
+				t.Errorf("no times shifted: %v", got)
+			}
+
+			other := firings(JitterSchedule{Schedule: underlying, Window: test.window, Seed: 5678}, now, 50)
+			if test.window > 0 && reflect.DeepEqual(got, other) {
+				t.Errorf("different seeds produced the same times: %v", got)
+			}
+		})
+	}
+}
+
+func TestJitterShiftBound(t *testing.T) {
+	schedule := JitterSchedule{Window: 15 * time.Minute, Seed: 42}
+	start := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 1000; i++ {
+		shift := schedule.shift(start.Add(time.Duration(i) * time.Hour))
+		if shift < -schedule.Window || shift > schedule.Window {
+			t.Fatalf("shift %s out of range ±%s", shift, schedule.Window)
+		}
+		if shift%time.Second != 0 {
+			t.Fatalf("shift %s not rounded to the second", shift)
+		}
+	}
+}
+
+func TestRandomSchedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end Clock
+		count      int
+		now        time.Time
+	}{
+		{
+			name:  "evening",
+			start: NewClock(18, 0, 0),
+			end:   NewClock(23, 30, 0),
+			count: 4,
+			now:   time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "overnight",
+			start: NewClock(22, 0, 0),
+			end:   NewClock(2, 0, 0),
+			count: 6,
+			now:   time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "many events",
+			start: NewClock(6, 0, 0),
+			end:   NewClock(9, 0, 0),
+			count: 8,
+			now:   time.Date(2026, time.October, 17, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "single event",
+			start: NewClock(19, 0, 0),
+			end:   NewClock(19, 30, 0),
+			count: 2,
+			now:   time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule := func(seed int64, index int) RandomSchedule {
+				return RandomSchedule{Start: test.start, End: test.end, Seed: seed, Count: test.count, Index: index}
+			}
+
+			type event struct {
+				time  time.Time
+				index int
+			}
+			var events []event
+			for index := 0; index < test.count; index++ {
+				got := firings(schedule(1234, index), test.now, 10)
+				if len(got) != 10 {
+					t.Fatalf("index %d: got %d firings, want 10", index, len(got))
+				}
+
+				if again := firings(schedule(1234, index), test.now, 10); !reflect.DeepEqual(got, again) {
+					t.Errorf("index %d: same seed produced different times:\n%v\n%v", index, got, again)
+				}
+				if other := firings(schedule(5678, index), test.now, 10); reflect.DeepEqual(got, other) {
+					t.Errorf("index %d: different seeds produced the same times: %v", index, got)
+				}
+
+				for _, fired := range got {
+					if !fired.After(test.now) {
+						t.Errorf("index %d: fired at %s, expected after %s", index, fired, test.now)
+					}
+					if !inWindow(fired, test.start, test.end) {
+						t.Errorf("index %d: fired at %s, expected between %s and %s", index, fired, test.start, test.end)
+					}
+					events = append(events, event{time: fired, index: index})
+				}
+			}
+
+			// the first ten days of events are complete, so even (on) and
+			// odd (off) indexes alternate and each day's events are in
+			// index order
+			sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+			for i, e := range events[:10*test.count-test.count] {
+				if e.index%2 != i%2 {
+					t.Fatalf("event %d at %s has index %d, expected on and off events to alternate", i, e.time, e.index)
+				}
+				if e.index != i%test.count {
+					t.Fatalf("event %d at %s has index %d, expected %d", i, e.time, e.index, i%test.count)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomScheduleIndexOutOfRange(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+	for _, index := range []int{-1, 2} {
+		schedule := RandomSchedule{Start: NewClock(18, 0, 0), End: NewClock(20, 0, 0), Count: 2, Index: index}
+		if got := schedule.Next(now); !got.IsZero() {
+			t.Errorf("index %d: got %s, want zero time", index, got)
+		}
+	}
+}
+
+// inWindow reports whether the clock time of t falls within the daily
+// window from start to end, which may extend past midnight
+func inWindow(t time.Time, start, end Clock) bool {
+	c := NewClock(t.Hour(), t.Minute(), t.Second())
+	if end <= start {
+		return c >= start || c < end
+	}
+	return c >= start && c < end
+}
@@ -0,0 +1,211 @@
+package lamplighter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	SunrisePrefix          = "@sunrise"
+	SunsetPrefix           = "@sunset"
+	DawnPrefix             = "@dawn"
+	DuskPrefix             = "@dusk"
+	CivilDawnPrefix        = "@civil-dawn"
+	CivilDuskPrefix        = "@civil-dusk"
+	NauticalDawnPrefix     = "@nautical-dawn"
+	NauticalDuskPrefix     = "@nautical-dusk"
+	AstronomicalDawnPrefix = "@astronomical-dawn"
+	AstronomicalDuskPrefix = "@astronomical-dusk"
+	SolarNoonPrefix        = "@solar-noon"
+	ElevationPrefix        = "@elevation"
+	AtPrefix               = "@at"
+)
+
+// Parser extends the standard cron spec parser with descriptors for
+// solar events at a given location. Each solar descriptor may be
+// followed by an offset parseable by time.ParseDuration, for example
+// "@sunset -1h" or "@civil-dusk +15m".
+//
+// The elevation descriptor takes an angle and direction before the
+// offset, for example "@elevation -6deg rising" or
+// "@elevation 10deg setting -5m".
+//
+// Solar schedules can be limited to certain days by following the offset
+// with weekdays, months, or a date range, for example
+// "@sunset -30m mon-fri" or "@sunrise +0 between 03-01 and 10-31".
+//
+// If Fallback is set, solar schedules fire at that time of day on days
+// when their event does not occur, such as sunset during polar day.
+// Otherwise, those days are skipped.
+//
+// If NotBefore or NotAfter are set, solar schedules are clamped to those
+// times of day.
+//
+// The at descriptor fires once at the given time, for example
+// "@at 2026-12-24T17:00".
+type Parser struct {
+	Location  Location
+	Fallback  *Clock
+	NotBefore *Clock
+	NotAfter  *Clock
+}
+
+// Parse returns a new schedule for the given spec
+func (p Parser) Parse(spec string) (cron.Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return cron.ParseStandard(spec)
+	}
+
+	descriptor, args := fields[0], fields[1:]
+	if descriptor == ElevationPrefix {
+		return p.elevation(args)
+	}
+	if descriptor == AtPrefix {
+		return p.at(args)
+	}
+
+	if p.solar(descriptor, 0) == nil {
+		if p.Fallback != nil || p.NotBefore != nil || p.NotAfter != nil {
+			return nil, fmt.Errorf("fallback and bounds are only supported by solar schedules")
+		}
+		return cron.ParseStandard(spec)
+	}
+
+	// solar noon occurs every day, so there is nothing to fall back from
+	if descriptor == SolarNoonPrefix && p.Fallback != nil {
+		return nil, fmt.Errorf("fallback is not supported by %s, which occurs every day", SolarNoonPrefix)
+	}
+
+	offset, args, err := parseOffset(descriptor, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConstraint(p.bound(p.solar(descriptor, offset)), args)
+}
+
+// elevation parses the arguments of an elevation descriptor
+func (p Parser) elevation(args []string) (cron.Schedule, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s requires an elevation and direction", ElevationPrefix)
+	}
+
+	elevation, err := parseElevation(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	rising, err := parseDirection(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	offset, args, err := parseOffset(ElevationPrefix, args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := ElevationSchedule{
+		Location:  p.Location,
+		Elevation: elevation,
+		Rising:    rising,
+		Offset:    offset,
+		Fallback:  p.Fallback,
+	}
+	return parseConstraint(p.bound(schedule), args)
+}
+
+// at parses the arguments of an at descriptor
+func (p Parser) at(args []string) (cron.Schedule, error) {
+	if p.Fallback != nil || p.NotBefore != nil || p.NotAfter != nil {
+		return nil, fmt.Errorf("fallback and bounds are only supported by solar schedules")
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s requires a single time", AtPrefix)
+	}
+
+	t, err := ParseAt(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return AtSchedule{Time: t}, nil
+}
+
+// bound wraps the schedule in a BoundedSchedule if either bound is set
+func (p Parser) bound(schedule cron.Schedule) cron.Schedule {
+	if p.NotBefore == nil && p.NotAfter == nil {
+		return schedule
+	}
+
+	return BoundedSchedule{
+		Schedule:  schedule,
+		NotBefore: p.NotBefore,
+		NotAfter:  p.NotAfter,
+	}
+}
+
+// parseOffset parses the optional offset following a solar descriptor
+// and returns the remaining arguments. Offsets are distinguished from day
+// constraints by their leading sign or digit.
+func parseOffset(descriptor string, args []string) (time.Duration, []string, error) {
+	if len(args) == 0 || !strings.ContainsAny(args[0][:1], "+-0123456789") {
+		return 0, args, nil
+	}
+
+	offset, err := time.ParseDuration(args[0])
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse %s offset: %w", descriptor, err)
+	}
+
+	return offset, args[1:], nil
+}
+
+// solar returns the schedule for the given solar descriptor, or nil if
+// the descriptor is not recognized
+func (p Parser) solar(descriptor string, offset time.Duration) cron.Schedule {
+	switch descriptor {
+	case SunrisePrefix:
+		return SunriseSchedule{Location: p.Location, Offset: offset, Fallback: p.Fallback}
+	case SunsetPrefix:
+		return SunsetSchedule{Location: p.Location, Offset: offset, Fallback: p.Fallback}
+	case DawnPrefix, CivilDawnPrefix:
+		return DawnSchedule{Location: p.Location, Twilight: CivilTwilight, Offset: offset, Fallback: p.Fallback}
+	case DuskPrefix, CivilDuskPrefix:
+		return DuskSchedule{Location: p.Location, Twilight: CivilTwilight, Offset: offset, Fallback: p.Fallback}
+	case NauticalDawnPrefix:
+		return DawnSchedule{Location: p.Location, Twilight: NauticalTwilight, Offset: offset, Fallback: p.Fallback}
+	case NauticalDuskPrefix:
+		return DuskSchedule{Location: p.Location, Twilight: NauticalTwilight, Offset: offset, Fallback: p.Fallback}
+	case AstronomicalDawnPrefix:
+		return DawnSchedule{Location: p.Location, Twilight: AstronomicalTwilight, Offset: offset, Fallback: p.Fallback}
+	case AstronomicalDuskPrefix:
+		return DuskSchedule{Location: p.Location, Twilight: AstronomicalTwilight, Offset: offset, Fallback: p.Fallback}
+	case SolarNoonPrefix:
+		return SolarNoonSchedule{Location: p.Location, Offset: offset}
+	default:
+		return nil
+	}
+}
+
+// Previous returns the latest activation time of the schedule which is
+// not after now. Schedules are searched up to a month into the past; if
+// the schedule has not fired in that time, the zero time is returned.
+func Previous(schedule cron.Schedule, now time.Time) time.Time {
+	for _, lookback := range []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 32 * 24 * time.Hour} {
+		var previous time.Time
+		for t := schedule.Next(now.Add(-lookback)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+			previous = t
+		}
+
+		if !previous.IsZero() {
+			return previous
+		}
+	}
+
+	return time.Time{}
+}
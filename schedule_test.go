@@ -0,0 +1,647 @@
+package lamplighter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	newYork = Location{Latitude: 40.71, Longitude: -74.01}
+	tromso  = Location{Latitude: 69.65, Longitude: 18.96}
+)
+
+// clock returns a pointer to the given time of day
+func clock(hour, minute int) *Clock {
+	c := NewClock(hour, minute, 0)
+	return &c
+}
+
+// mustParse parses a standard cron spec
+func mustParse(t *testing.T, spec string) cron.Schedule {
+	t.Helper()
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		t.Fatalf("parse %q: %s", spec, err)
+	}
+	return schedule
+}
+
+// loadLocation loads the named time zone
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("load time zone: %s", err)
+	}
+	return loc
+}
+
+func TestParse(t *testing.T) {
+	at, err := time.ParseInLocation("2006-01-02T15:04", "2026-12-24T17:00", time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		parser Parser
+		spec   string
+		want   cron.Schedule
+	}{
+		{
+			name: "sunset",
+			spec: "@sunset",
+			want: SunsetSchedule{Location: newYork},
+		},
+		{
+			name: "negative offset",
+			spec: "@sunset -1h",
+			want: SunsetSchedule{Location: newYork, Offset: -time.Hour},
+		},
+		{
+			name: "positive offset",
+			spec: "@sunrise +15m",
+			want: SunriseSchedule{Location: newYork, Offset: 15 * time.Minute},
+		},
+		{
+			name: "unsigned offset",
+			spec: "@sunrise 1h30m",
+			want: SunriseSchedule{Location: newYork, Offset: 90 * time.Minute},
+		},
+		{
+			name: "dawn",
+			spec: "@dawn",
+			want: DawnSchedule{Location: newYork, Twilight: CivilTwilight},
+		},
+		{
+			name: "civil dusk",
+			spec: "@civil-dusk +15m",
+			want: DuskSchedule{Location: newYork, Twilight: CivilTwilight, Offset: 15 * time.Minute},
+		},
+		{
+			name: "nautical dawn",
+			spec: "@nautical-dawn",
+			want: DawnSchedule{Location: newYork, Twilight: NauticalTwilight},
+		},
+		{
+			name: "astronomical dusk",
+			spec: "@astronomical-dusk -10m",
+			want: DuskSchedule{Location: newYork, Twilight: AstronomicalTwilight, Offset: -10 * time.Minute},
+		},
+		{
+			name: "solar noon",
+			spec: "@solar-noon +30m",
+			want: SolarNoonSchedule{Location: newYork, Offset: 30 * time.Minute},
+		},
+		{
+			name: "elevation",
+			spec: "@elevation -6deg rising",
+			want: ElevationSchedule{Location: newYork, Elevation: -6, Rising: true},
+		},
+		{
+			name: "elevation with offset",
+			spec: "@elevation 10° setting -5m",
+			want: ElevationSchedule{Location: newYork, Elevation: 10, Offset: -5 * time.Minute},
+		},
+		{
+			name: "weekday range",
+			spec: "@sunrise mon-fri",
+			want: ConstrainedSchedule{
+				Schedule: SunriseSchedule{Location: newYork},
+				Weekdays: 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday,
+			},
+		},
+		{
+			name: "weekday list after offset",
+			spec: "@sunset +0 sat,sun",
+			want: ConstrainedSchedule{
+				Schedule: SunsetSchedule{Location: newYork},
+				Weekdays: 1<<time.Saturday | 1<<time.Sunday,
+			},
+		},
+		{
+			name: "reversed weekday range wraps",
+			spec: "@sunset fri-mon",
+			want: ConstrainedSchedule{
+				Schedule: SunsetSchedule{Location: newYork},
+				Weekdays: 1<<time.Friday | 1<<time.Saturday | 1<<time.Sunday | 1<<time.Monday,
+			},
+		},
+		{
+			name: "reversed month range wraps",
+			spec: "@sunset nov-feb",
+			want: ConstrainedSchedule{
+				Schedule: SunsetSchedule{Location: newYork},
+				Months:   1<<time.November | 1<<time.December | 1<<time.January | 1<<time.February,
+			},
+		},
+		{
+			name: "weekdays and months ignore case",
+			spec: "@sunset MON,Wed Jan",
+			want: ConstrainedSchedule{
+				Schedule: SunsetSchedule{Location: newYork},
+				Weekdays: 1<<time.Monday | 1<<time.Wednesday,
+				Months:   1 << time.January,
+			},
+		},
+		{
+			name: "between",
+			spec: "@sunrise +0 between 03-01 and 10-31",
+			want: ConstrainedSchedule{
+				Schedule: SunriseSchedule{Location: newYork},
+				Between:  &DateRange{From: MonthDay{Month: time.March, Day: 1}, To: MonthDay{Month: time.October, Day: 31}},
+			},
+		},
+		{
+			name: "reversed between wraps",
+			spec: "@sunset between 12-01 AND 01-31 sat",
+			want: ConstrainedSchedule{
+				Schedule: SunsetSchedule{Location: newYork},
+				Weekdays: 1 << time.Saturday,
+				Between:  &DateRange{From: MonthDay{Month: time.December, Day: 1}, To: MonthDay{Month: time.January, Day: 31}},
+			},
+		},
+		{
+			name:   "fallback",
+			parser: Parser{Fallback: clock(22, 0)},
+			spec:   "@sunset",
+			want:   SunsetSchedule{Location: newYork, Fallback: clock(22, 0)},
+		},
+		{
+			name:   "elevation fallback",
+			parser: Parser{Fallback: clock(7, 0)},
+			spec:   "@elevation 5deg rising",
+			want:   ElevationSchedule{Location: newYork, Elevation: 5, Rising: true, Fallback: clock(7, 0)},
+		},
+		{
+			name:   "bounds",
+			parser: Parser{NotBefore: clock(16, 30), NotAfter: clock(20, 0)},
+			spec:   "@sunset -1h",
+			want: BoundedSchedule{
+				Schedule:  SunsetSchedule{Location: newYork, Offset: -time.Hour},
+				NotBefore: clock(16, 30),
+				NotAfter:  clock(20, 0),
+			},
+		},
+		{
+			name:   "bounds and constraint",
+			parser: Parser{NotAfter: clock(7, 0)},
+			spec:   "@sunrise mon-fri",
+			want: ConstrainedSchedule{
+				Schedule: BoundedSchedule{
+					Schedule: SunriseSchedule{Location: newYork},
+					NotAfter: clock(7, 0),
+				},
+				Weekdays: 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday,
+			},
+		},
+		{
+			name: "at",
+			spec: "@at 2026-12-24T17:00",
+			want: AtSchedule{Time: at},
+		},
+		{
+			name: "standard",
+			spec: "30 7 * * 1-5",
+			want: mustParse(t, "30 7 * * 1-5"),
+		},
+		{
+			name: "standard descriptor",
+			spec: "@daily",
+			want: mustParse(t, "@daily"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := test.parser
+			parser.Location = newYork
+
+			got, err := parser.Parse(test.spec)
+			if err != nil {
+				t.Fatalf("parse %q: %s", test.spec, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser Parser
+		spec   string
+	}{
+		{name: "bad offset", spec: "@sunset -1x"},
+		{name: "unknown constraint", spec: "@sunset soon"},
+		{name: "unknown weekday", spec: "@sunset mon-fry"},
+		{name: "empty list item", spec: "@sunset mon,,fri"},
+		{name: "trailing comma", spec: "@sunset mon,"},
+		{name: "empty range end", spec: "@sunset mon-"},
+		{name: "mixed weekdays and months", spec: "@sunset mon,jan"},
+		{name: "between without and", spec: "@sunset between 03-01 10-31"},
+		{name: "between missing end", spec: "@sunset between 03-01 and"},
+		{name: "between bad month", spec: "@sunset between 13-01 and 01-31"},
+		{name: "between bad day", spec: "@sunset between 03-00 and 03-31"},
+		{name: "between bad format", spec: "@sunset between 2026-03-01 and 03-31"},
+		{name: "elevation missing direction", spec: "@elevation -6deg"},
+		{name: "elevation out of range", spec: "@elevation 95deg rising"},
+		{name: "elevation bad angle", spec: "@elevation low rising"},
+		{name: "elevation bad direction", spec: "@elevation -6deg up"},
+		{name: "at missing time", spec: "@at"},
+		{name: "at bad time", spec: "@at tomorrow"},
+		{name: "at extra arguments", spec: "@at 2026-12-24T17:00 mon"},
+		{name: "unknown descriptor", spec: "@sundown"},
+		{name: "bad standard spec", spec: "61 7 * * *"},
+		{name: "fallback on solar noon", parser: Parser{Fallback: clock(12, 0)}, spec: "@solar-noon"},
+		{name: "fallback on standard spec", parser: Parser{Fallback: clock(7, 0)}, spec: "0 7 * * *"},
+		{name: "bounds on standard spec", parser: Parser{NotBefore: clock(7, 0)}, spec: "0 7 * * *"},
+		{name: "bounds on at", parser: Parser{NotAfter: clock(7, 0)}, spec: "@at 2026-12-24T17:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := test.parser
+			parser.Location = newYork
+
+			schedule, err := parser.Parse(test.spec)
+			if err == nil {
+				t.Errorf("parse %q: expected error, got %#v", test.spec, schedule)
+			}
+		})
+	}
+}
+
+func TestSolarOrder(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+	next := func(spec string) time.Time {
+		t.Helper()
+
+		schedule, err := Parser{Location: newYork}.Parse(spec)
+		if err != nil {
+			t.Fatalf("parse %q: %s", spec, err)
+		}
+		return schedule.Next(now)
+	}
+
+	// over one day, the events occur in this order
+	specs := []string{
+		"@astronomical-dawn",
+		"@nautical-dawn",
+		"@civil-dawn",
+		"@sunrise",
+		"@solar-noon",
+		"@sunset",
+		"@civil-dusk",
+		"@nautical-dusk",
+		"@astronomical-dusk",
+	}
+	var previous time.Time
+	for _, spec := range specs {
+		got := next(spec)
+		if !got.After(previous) {
+			t.Errorf("%s at %s, expected after %s", spec, got, previous)
+		}
+		previous = got
+	}
+
+	if got := next("@sunrise").Sub(now); got > 24*time.Hour {
+		t.Errorf("sunrise %s after now, expected within a day", got)
+	}
+	if dawn, elevation := next("@civil-dawn"), next("@elevation -6deg rising"); !dawn.Equal(elevation) {
+		t.Errorf("civil dawn at %s, -6deg rising at %s", dawn, elevation)
+	}
+	if dusk, elevation := next("@nautical-dusk"), next("@elevation -12deg setting"); !dusk.Equal(elevation) {
+		t.Errorf("nautical dusk at %s, -12deg setting at %s", dusk, elevation)
+	}
+	if sunset, offset := next("@sunset"), next("@sunset -1h"); sunset.Sub(offset) != time.Hour {
+		t.Errorf("sunset at %s, sunset -1h at %s", sunset, offset)
+	}
+	if sunset, offset := next("@sunset"), next("@sunset +15m"); offset.Sub(sunset) != 15*time.Minute {
+		t.Errorf("sunset at %s, sunset +15m at %s", sunset, offset)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	// there is no sunset in Tromsø from late May until late July
+	now := time.Date(2026, time.June, 20, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		parser   Parser
+		spec     string
+		want     time.Time
+		fallback bool
+	}{
+		{
+			name:     "fallback",
+			parser:   Parser{Fallback: clock(22, 0)},
+			spec:     "@sunset",
+			want:     time.Date(2026, time.June, 20, 22, 0, 0, 0, time.UTC),
+			fallback: true,
+		},
+		{
+			name:     "constrained fallback",
+			parser:   Parser{Fallback: clock(22, 0)},
+			spec:     "@sunset mon",
+			want:     time.Date(2026, time.June, 22, 22, 0, 0, 0, time.UTC),
+			fallback: true,
+		},
+		{
+			name:     "bounded fallback",
+			parser:   Parser{Fallback: clock(22, 0), NotAfter: clock(21, 0)},
+			spec:     "@sunset",
+			want:     time.Date(2026, time.June, 20, 21, 0, 0, 0, time.UTC),
+			fallback: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := test.parser
+			parser.Location = tromso
+
+			schedule, err := parser.Parse(test.spec)
+			if err != nil {
+				t.Fatalf("parse %q: %s", test.spec, err)
+			}
+
+			if got := schedule.Next(now); !got.Equal(test.want) {
+				t.Errorf("next: got %s, want %s", got, test.want)
+			}
+			if got := schedule.(FallbackSchedule).IsFallback(now); got != test.fallback {
+				t.Errorf("fallback: got %t, want %t", got, test.fallback)
+			}
+		})
+	}
+
+	// without a fallback, days without a sunset are skipped
+	schedule, err := Parser{Location: tromso}.Parse("@sunset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Next(now); got.Month() != time.July {
+		t.Errorf("got %s, expected the first sunset in July", got)
+	}
+}
+
+func TestConstrainedSchedule(t *testing.T) {
+	noon := mustParse(t, "CRON_TZ=UTC 0 12 * * *")
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule ConstrainedSchedule
+		now      time.Time
+		want     time.Time
+	}{
+		{
+			name:     "unconstrained",
+			schedule: ConstrainedSchedule{Schedule: noon},
+			now:      date(2026, time.October, 17, 0),
+			want:     date(2026, time.October, 17, 12),
+		},
+		{
+			name:     "weekdays skip weekend",
+			schedule: ConstrainedSchedule{Schedule: noon, Weekdays: 1<<time.Monday | 1<<time.Friday},
+			now:      date(2026, time.October, 17, 0),
+			want:     date(2026, time.October, 19, 12),
+		},
+		{
+			name:     "weekday today",
+			schedule: ConstrainedSchedule{Schedule: noon, Weekdays: 1 << time.Saturday},
+			now:      date(2026, time.October, 17, 11),
+			want:     date(2026, time.October, 17, 12),
+		},
+		{
+			name:     "weekday passed today",
+			schedule: ConstrainedSchedule{Schedule: noon, Weekdays: 1 << time.Saturday},
+			now:      date(2026, time.October, 17, 12),
+			want:     date(2026, time.October, 24, 12),
+		},
+		{
+			name:     "months",
+			schedule: ConstrainedSchedule{Schedule: noon, Months: 1<<time.November | 1<<time.February},
+			now:      date(2026, time.October, 14, 0),
+			want:     date(2026, time.November, 1, 12),
+		},
+		{
+			name:     "weekdays and months",
+			schedule: ConstrainedSchedule{Schedule: noon, Weekdays: 1 << time.Sunday, Months: 1 << time.November},
+			now:      date(2026, time.October, 14, 0),
+			want:     date(2026, time.November, 1, 12),
+		},
+		{
+			name: "between",
+			schedule: ConstrainedSchedule{
+				Schedule: noon,
+				Between:  &DateRange{From: MonthDay{Month: time.December, Day: 24}, To: MonthDay{Month: time.December, Day: 26}},
+			},
+			now:  date(2026, time.October, 14, 0),
+			want: date(2026, time.December, 24, 12),
+		},
+		{
+			name: "wrapped between inside",
+			schedule: ConstrainedSchedule{
+				Schedule: noon,
+				Between:  &DateRange{From: MonthDay{Month: time.December, Day: 24}, To: MonthDay{Month: time.January, Day: 2}},
+			},
+			now:  date(2026, time.December, 30, 13),
+			want: date(2026, time.December, 31, 12),
+		},
+		{
+			name: "wrapped between last day",
+			schedule: ConstrainedSchedule{
+				Schedule: noon,
+				Between:  &DateRange{From: MonthDay{Month: time.December, Day: 24}, To: MonthDay{Month: time.January, Day: 2}},
+			},
+			now:  date(2027, time.January, 2, 0),
+			want: date(2027, time.January, 2, 12),
+		},
+		{
+			name: "wrapped between after",
+			schedule: ConstrainedSchedule{
+				Schedule: noon,
+				Between:  &DateRange{From: MonthDay{Month: time.December, Day: 24}, To: MonthDay{Month: time.January, Day: 2}},
+			},
+			now:  date(2027, time.January, 2, 13),
+			want: date(2027, time.December, 24, 12),
+		},
+		{
+			name: "never matches",
+			schedule: ConstrainedSchedule{
+				Schedule: noon,
+				Between:  &DateRange{From: MonthDay{Month: time.February, Day: 30}, To: MonthDay{Month: time.February, Day: 31}},
+			},
+			now: date(2026, time.October, 14, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.schedule.Next(test.now); !got.Equal(test.want) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBoundedSchedule(t *testing.T) {
+	afternoon := mustParse(t, "CRON_TZ=UTC 0 15 * * *")
+	date := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule BoundedSchedule
+		now      time.Time
+		want     time.Time
+	}{
+		{
+			name:     "within bounds",
+			schedule: BoundedSchedule{Schedule: afternoon, NotBefore: clock(14, 0), NotAfter: clock(16, 0)},
+			now:      date(17, 0, 0),
+			want:     date(17, 15, 0),
+		},
+		{
+			name:     "clamped to not before",
+			schedule: BoundedSchedule{Schedule: afternoon, NotBefore: clock(16, 30)},
+			now:      date(17, 0, 0),
+			want:     date(17, 16, 30),
+		},
+		{
+			name:     "clamped to not after",
+			schedule: BoundedSchedule{Schedule: afternoon, NotAfter: clock(14, 0)},
+			now:      date(17, 0, 0),
+			want:     date(17, 14, 0),
+		},
+		{
+			name:     "earlier event clamped past now",
+			schedule: BoundedSchedule{Schedule: afternoon, NotBefore: clock(16, 30)},
+			now:      date(17, 15, 30),
+			want:     date(17, 16, 30),
+		},
+		{
+			name:     "clamped time passed",
+			schedule: BoundedSchedule{Schedule: afternoon, NotAfter: clock(14, 0)},
+			now:      date(17, 14, 0),
+			want:     date(18, 14, 0),
+		},
+		{
+			name:     "reversed bounds fire at not before",
+			schedule: BoundedSchedule{Schedule: afternoon, NotBefore: clock(18, 0), NotAfter: clock(17, 0)},
+			now:      date(17, 0, 0),
+			want:     date(17, 18, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.schedule.Next(test.now); !got.Equal(test.want) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDaylightSaving(t *testing.T) {
+	// clocks in New York moved forward an hour on 2026-03-08
+	tz := loadLocation(t, "America/New_York")
+	now := time.Date(2026, time.March, 7, 12, 0, 0, 0, tz)
+
+	t.Run("bounds keep clock time", func(t *testing.T) {
+		schedule := BoundedSchedule{
+			Schedule:  mustParse(t, "CRON_TZ=America/New_York 0 15 * * *"),
+			NotBefore: clock(16, 30),
+		}
+
+		first := schedule.Next(now)
+		if want := time.Date(2026, time.March, 7, 16, 30, 0, 0, tz); !first.Equal(want) {
+			t.Errorf("first: got %s, want %s", first, want)
+		}
+		second := schedule.Next(first)
+		if want := time.Date(2026, time.March, 8, 16, 30, 0, 0, tz); !second.Equal(want) {
+			t.Errorf("second: got %s, want %s", second, want)
+		}
+		if got := second.Sub(first); got != 23*time.Hour {
+			t.Errorf("got %s between firings, want 23h", got)
+		}
+	})
+
+	t.Run("sunset", func(t *testing.T) {
+		schedule, err := Parser{Location: newYork}.Parse("@sunset")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := schedule.Next(now).In(tz)
+		second := schedule.Next(first).In(tz)
+		if first.Day() != 7 || second.Day() != 8 {
+			t.Errorf("got sunsets on %s and %s, expected March 7 and 8", first, second)
+		}
+
+		// sunsets are about a minute later each day in March, and the
+		// clocks moving forward makes the second an hour later again
+		if got := second.Sub(first); got < 24*time.Hour || got > 24*time.Hour+5*time.Minute {
+			t.Errorf("got %s between sunsets, expected just over 24h", got)
+		}
+		clockTime := func(t time.Time) time.Duration {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+		if got := clockTime(second) - clockTime(first); got < time.Hour || got > time.Hour+5*time.Minute {
+			t.Errorf("got sunsets at %s and %s, expected just over an hour later by the clock", first, second)
+		}
+	})
+}
+
+func TestConstraintTimeZone(t *testing.T) {
+	tz := loadLocation(t, "America/New_York")
+	now := time.Date(2026, time.June, 20, 12, 0, 0, 0, tz)
+
+	schedule, err := Parser{Location: newYork}.Parse("@sunset sat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// summer sunsets fall on the next day in UTC, so the weekday must be
+	// checked in the local time zone
+	got := schedule.Next(now)
+	if got.UTC().Weekday() != time.Sunday {
+		t.Fatalf("got sunset at %s, expected Sunday in UTC", got.UTC())
+	}
+	if local := got.In(tz); local.Weekday() != time.Saturday || local.Day() != 20 {
+		t.Errorf("got %s, expected Saturday June 20", local)
+	}
+}
+
+func TestPrevious(t *testing.T) {
+	noon := mustParse(t, "CRON_TZ=UTC 0 12 * * *")
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+
+	if got, want := Previous(noon, now), now; !got.Equal(want) {
+		t.Errorf("at activation time: got %s, want %s", got, want)
+	}
+	if got, want := Previous(noon, now.Add(-time.Minute)), now.AddDate(0, 0, -1); !got.Equal(want) {
+		t.Errorf("before activation time: got %s, want %s", got, want)
+	}
+
+	monthly := ConstrainedSchedule{Schedule: noon, Months: 1 << time.September}
+	if got, want := Previous(monthly, now), time.Date(2026, time.September, 30, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("constrained: got %s, want %s", got, want)
+	}
+
+	if got := Previous(AtSchedule{Time: now.Add(time.Hour)}, now); !got.IsZero() {
+		t.Errorf("future one-shot: got %s, want zero time", got)
+	}
+}
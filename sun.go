@@ -0,0 +1,176 @@
+package lamplighter
+
+import (
+	"math"
+	"time"
+
+	diurnal "github.com/nathan-osman/go-sunrise"
+	"github.com/robfig/cron/v3"
+)
+
+// maxSearchDays limits how far into the future a schedule will look for
+// a day on which its solar event occurs
+const maxSearchDays = 366
+
+// Twilight is the solar elevation, in degrees, which marks the start of
+// dawn and the end of dusk
+type Twilight float64
+
+const (
+	CivilTwilight        Twilight = -6
+	NauticalTwilight     Twilight = -12
+	AstronomicalTwilight Twilight = -18
+)
+
+// solarDay holds the position of the sun at solar noon on a given day
+type solarDay struct {
+	transit     float64 // julian day
+	declination float64 // degrees
+}
+
+// newSolarDay calculates solar noon and the sun's declination for the
+// given date and longitude using the same approach as
+// diurnal.SunriseSunset
+func newSolarDay(longitude float64, year int, month time.Month, day int) solarDay {
+	var (
+		d                 = diurnal.MeanSolarNoon(longitude, year, month, day)
+		solarAnomaly      = diurnal.SolarMeanAnomaly(d)
+		equationOfCenter  = diurnal.EquationOfCenter(solarAnomaly)
+		eclipticLongitude = diurnal.EclipticLongitude(solarAnomaly, equationOfCenter, d)
+	)
+
+	return solarDay{
+		transit:     diurnal.SolarTransit(d, solarAnomaly, eclipticLongitude),
+		declination: diurnal.Declination(eclipticLongitude),
+	}
+}
+
+// noon returns the time at which the sun is at its highest elevation
+func (s solarDay) noon() time.Time {
+	return diurnal.JulianDayToTime(s.transit)
+}
+
+// crossing returns the times at which the sun passes through the given
+// elevation, in degrees, while rising and setting. If the sun does not
+// reach or does not drop below the elevation on this day, ok is false.
+func (s solarDay) crossing(latitude, elevation float64) (rising, setting time.Time, ok bool) {
+	var (
+		latitudeRad    = latitude * diurnal.Degree
+		declinationRad = s.declination * diurnal.Degree
+		numerator      = math.Sin(elevation*diurnal.Degree) - math.Sin(latitudeRad)*math.Sin(declinationRad)
+		denominator    = math.Cos(latitudeRad) * math.Cos(declinationRad)
+		ratio          = numerator / denominator
+	)
+
+	if ratio > 1 || ratio < -1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	frac := math.Acos(ratio) / diurnal.Degree / 360
+	return diurnal.JulianDayToTime(s.transit - frac), diurnal.JulianDayToTime(s.transit + frac), true
+}
+
+// elevation returns the sun's elevation, in degrees, at the given
+// latitude and time
+func (s solarDay) elevation(latitude float64, t time.Time) float64 {
+	// the hour angle is 15 degrees per hour from solar noon
+	hourAngle := math.Mod((diurnal.TimeToJulianDay(t)-s.transit)*360, 360)
+	if hourAngle > 180 {
+		hourAngle -= 360
+	} else if hourAngle < -180 {
+		hourAngle += 360
+	}
+
+	var (
+		latitudeRad    = latitude * diurnal.Degree
+		declinationRad = s.declination * diurnal.Degree
+		sinElevation   = math.Sin(latitudeRad)*math.Sin(declinationRad) + math.Cos(latitudeRad)*math.Cos(declinationRad)*math.Cos(hourAngle*diurnal.Degree)
+	)
+	return math.Asin(sinElevation) / diurnal.Degree
+}
+
+// SolarEvents holds the times of the sun's daily events on a given day.
+// Events which don't occur on that day, such as sunset during polar day,
+// are the zero time.
+type SolarEvents struct {
+	Dawn    time.Time // civil dawn
+	Sunrise time.Time
+	Noon    time.Time
+	Sunset  time.Time
+	Dusk    time.Time // civil dusk
+}
+
+// SolarEventsOn returns the times of the solar events at the location on
+// the given date
+func SolarEventsOn(location Location, date time.Time) SolarEvents {
+	year, month, day := date.Date()
+	event := func(fn dailyEvent) time.Time {
+		t, _ := fn(year, month, day)
+		return t
+	}
+
+	return SolarEvents{
+		Dawn:    event(DawnSchedule{Location: location, Twilight: CivilTwilight}.event),
+		Sunrise: event(SunriseSchedule{Location: location}.event),
+		Noon:    event(SolarNoonSchedule{Location: location}.event),
+		Sunset:  event(SunsetSchedule{Location: location}.event),
+		Dusk:    event(DuskSchedule{Location: location, Twilight: CivilTwilight}.event),
+	}
+}
+
+// FallbackSchedule is implemented by schedules which fire at a fixed
+// clock time on days when their solar event does not occur, such as
+// sunset during polar day
+type FallbackSchedule interface {
+	Next(time.Time) time.Time
+
+	// IsFallback reports whether the next activation time after now is
+	// the fallback clock time rather than a solar event
+	IsFallback(now time.Time) bool
+}
+
+// wrappedFallback reports whether the next activation time after now of
+// a schedule wrapping another is the underlying schedule's fallback time.
+// next returns the wrapper's activation time and the time from which the
+// underlying schedule produced it.
+func wrappedFallback(schedule cron.Schedule, next func(time.Time) (time.Time, time.Time), now time.Time) bool {
+	fallback, ok := schedule.(FallbackSchedule)
+	if !ok {
+		return false
+	}
+
+	t, from := next(now)
+	return !t.IsZero() && fallback.IsFallback(from)
+}
+
+// dailyEvent returns the time of a solar event on a given day. If the
+// event does not occur on that day, ok is false.
+type dailyEvent func(year int, month time.Month, day int) (t time.Time, ok bool)
+
+// nextDaily returns the first time after now at which the event for a
+// given day, shifted by offset, occurs. Days on which the event does not
+// occur use the fallback clock time if it is non-nil and are skipped
+// otherwise. If no event occurs within maxSearchDays, the zero time is
+// returned.
+func nextDaily(now time.Time, offset time.Duration, fallback *Clock, event dailyEvent) (next time.Time, isFallback bool) {
+	// start with yesterday to catch negative offsets which shift
+	// tomorrow's event into today
+	date := now.AddDate(0, 0, -1)
+	for i := 0; i < maxSearchDays; i++ {
+		t, ok := event(date.Year(), date.Month(), date.Day())
+		if ok {
+			t = t.Add(offset)
+			if t.After(now) {
+				return t, false
+			}
+		} else if fallback != nil {
+			t = fallback.On(date)
+			if t.After(now) {
+				return t, true
+			}
+		}
+		date = date.AddDate(0, 0, 1)
+	}
+
+	return time.Time{}, false
+}
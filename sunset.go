@@ -9,11 +9,13 @@ import (
 type SunriseSchedule struct {
 	Location Location      `json:"location"`
 	Offset   time.Duration `json:"offset"`
+	Fallback *Clock        `json:"fallback,omitempty"`
 }
 
 type SunsetSchedule struct {
 	Location Location      `json:"location"`
 	Offset   time.Duration `json:"offset"`
+	Fallback *Clock        `json:"fallback,omitempty"`
 }
 
 type Location struct {
@@ -22,59 +24,53 @@ type Location struct {
 }
 
 // Next returns the time of next sunrise, given the SunriseSchedule's
-// location value
+// location value. During polar day or night, days without a sunrise are
+// skipped unless a fallback is set.
 //
 // This implements robfig/cron.Schedule
 func (s SunriseSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return next
+}
+
+func (s SunriseSchedule) IsFallback(now time.Time) bool {
+	_, isFallback := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return isFallback
+}
+
+func (s SunriseSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
 	sunrise, _ := diurnal.SunriseSunset(
 		s.Location.Latitude,
 		s.Location.Longitude,
-		now.Year(),
-		now.Month(),
-		now.Day(),
+		year,
+		month,
+		day,
 	)
-	lightTime := sunrise.Add(s.Offset)
-
-	if now.After(lightTime) || now.Equal(lightTime) {
-		tomorrow := now.AddDate(0, 0, 1)
-		sunrise, _ = diurnal.SunriseSunset(
-			s.Location.Latitude,
-			s.Location.Longitude,
-			tomorrow.Year(),
-			tomorrow.Month(),
-			tomorrow.Day(),
-		)
-		lightTime = sunrise.Add(s.Offset)
-	}
-
-	return lightTime
+	return sunrise, !sunrise.IsZero()
 }
 
 // Next returns the time of next sunset, given the SunsetSchedule's
-// location value
+// location value. During polar day or night, days without a sunset are
+// skipped unless a fallback is set.
 //
 // This implements robfig/cron.Schedule
 func (s SunsetSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return next
+}
+
+func (s SunsetSchedule) IsFallback(now time.Time) bool {
+	_, isFallback := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return isFallback
+}
+
+func (s SunsetSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
 	_, sunset := diurnal.SunriseSunset(
 		s.Location.Latitude,
 		s.Location.Longitude,
-		now.Year(),
-		now.Month(),
-		now.Day(),
+		year,
+		month,
+		day,
 	)
-	lightTime := sunset.Add(s.Offset)
-
-	if now.After(lightTime) || now.Equal(lightTime) {
-		tomorrow := now.AddDate(0, 0, 1)
-		_, sunset = diurnal.SunriseSunset(
-			s.Location.Latitude,
-			s.Location.Longitude,
-			tomorrow.Year(),
-			tomorrow.Month(),
-			tomorrow.Day(),
-		)
-		lightTime = sunset.Add(s.Offset)
-	}
-
-	return lightTime
+	return sunset, !sunset.IsZero()
 }
@@ -0,0 +1,75 @@
+package lamplighter
+
+import (
+	"time"
+)
+
+type DawnSchedule struct {
+	Location Location      `json:"location"`
+	Twilight Twilight      `json:"twilight"`
+	Offset   time.Duration `json:"offset"`
+	Fallback *Clock        `json:"fallback,omitempty"`
+}
+
+type DuskSchedule struct {
+	Location Location      `json:"location"`
+	Twilight Twilight      `json:"twilight"`
+	Offset   time.Duration `json:"offset"`
+	Fallback *Clock        `json:"fallback,omitempty"`
+}
+
+type SolarNoonSchedule struct {
+	Location Location      `json:"location"`
+	Offset   time.Duration `json:"offset"`
+}
+
+// Next returns the time of the next dawn, defined as the time at which
+// the rising sun reaches the DawnSchedule's twilight elevation
+//
+// This implements robfig/cron.Schedule
+func (s DawnSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return next
+}
+
+func (s DawnSchedule) IsFallback(now time.Time) bool {
+	_, isFallback := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return isFallback
+}
+
+func (s DawnSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
+	dawn, _, ok := newSolarDay(s.Location.Longitude, year, month, day).crossing(s.Location.Latitude, float64(s.Twilight))
+	return dawn, ok
+}
+
+// Next returns the time of the next dusk, defined as the time at which
+// the setting sun reaches the DuskSchedule's twilight elevation
+//
+// This implements robfig/cron.Schedule
+func (s DuskSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return next
+}
+
+func (s DuskSchedule) IsFallback(now time.Time) bool {
+	_, isFallback := nextDaily(now, s.Offset, s.Fallback, s.event)
+	return isFallback
+}
+
+func (s DuskSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
+	_, dusk, ok := newSolarDay(s.Location.Longitude, year, month, day).crossing(s.Location.Latitude, float64(s.Twilight))
+	return dusk, ok
+}
+
+// Next returns the time of the next solar noon, given the
+// SolarNoonSchedule's location value
+//
+// This implements robfig/cron.Schedule
+func (s SolarNoonSchedule) Next(now time.Time) time.Time {
+	next, _ := nextDaily(now, s.Offset, nil, s.event)
+	return next
+}
+
+func (s SolarNoonSchedule) event(year int, month time.Month, day int) (time.Time, bool) {
+	return newSolarDay(s.Location.Longitude, year, month, day).noon(), true
+}